@@ -1,10 +1,13 @@
 package badger
 
 import (
+	"context"
 	"fmt"
 	"github.com/qiaojun2016/basic/color"
+	"github.com/qiaojun2016/basic/health"
 	badgerDB "github.com/dgraph-io/badger/v3"
 	"log"
+	"time"
 )
 
 //https://gist.github.com/alexanderbez/d99fd0383ad57e991e9af9adcbb70b9d
@@ -49,6 +52,44 @@ func (s server) Set(namespace, key, value []byte) (err error) {
 	return
 }
 
+// SetTTL 写入并在ttl后过期
+func (s server) SetTTL(namespace, key, value []byte, ttl time.Duration) (err error) {
+	err = bdb.Update(func(txn *badgerDB.Txn) error {
+		return txn.SetEntry(badgerDB.NewEntry(badgerNamespaceKey(namespace, key), value).WithTTL(ttl))
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	return
+}
+
+// SetNX 不存在时写入并在ttl后过期，返回是否写入
+func (s server) SetNX(namespace, key, value []byte, ttl time.Duration) (ok bool, err error) {
+	nsKey := badgerNamespaceKey(namespace, key)
+	err = bdb.Update(func(txn *badgerDB.Txn) error {
+		_, getErr := txn.Get(nsKey)
+		switch getErr {
+		case nil:
+			return nil
+		case badgerDB.ErrKeyNotFound:
+		default:
+			return getErr
+		}
+		ok = true
+		return txn.SetEntry(badgerDB.NewEntry(nsKey, value).WithTTL(ttl))
+	})
+	if err != nil {
+		//并发写入同一个key时事务冲突，视为已存在
+		if err == badgerDB.ErrConflict {
+			return false, nil
+		}
+		log.Println(err)
+		return false, err
+	}
+	return
+}
+
 func (s server) Has(namespace, key []byte) (ok bool, err error) {
 	_, err = s.Get(namespace, key)
 	switch err {
@@ -82,6 +123,12 @@ func (s Server) Run() {
 		log.Fatal(color.Red, dbErr, color.Reset)
 	}
 	Dadger = new(server)
+	health.Register("badger", health.CheckerFunc(func(ctx context.Context) error {
+		if bdb.IsClosed() {
+			return fmt.Errorf("badger closed")
+		}
+		return nil
+	}))
 	color.Success("[badger] in memory connect success")
 }
 
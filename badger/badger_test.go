@@ -2,6 +2,7 @@ package badger
 
 import (
 	"testing"
+	"time"
 )
 
 func Test_badger(t *testing.T) {
@@ -43,3 +44,17 @@ func Test_badger(t *testing.T) {
 	}
 	t.Log(has)
 }
+
+func Test_badgerSetNX(t *testing.T) {
+	var namespace = []byte("nonce")
+	var key = []byte("n1")
+	Server{}.Run()
+	ok, err := Dadger.SetNX(namespace, key, []byte{1}, time.Minute)
+	if err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	ok, err = Dadger.SetNX(namespace, key, []byte{1}, time.Minute)
+	if err != nil || ok {
+		t.Fatal(ok, err)
+	}
+}
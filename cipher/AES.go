@@ -4,8 +4,18 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/md5"
+	"fmt"
 )
 
+// AesKey 由 AccessKeyID 派生出16字节的AES密钥，密钥为 md5(AccessKeyID)，AesEncrypt 的IV与密钥相同。
+// AccessKeyID 由令牌的明文计算，令牌在同一个请求中明文传输，
+// 所以能看到请求的人都可以算出密钥并解密，加密只是混淆，不提供机密性，机密性依赖TLS
+func AesKey(ak []byte) []byte {
+	sum := md5.Sum(ak)
+	return sum[:]
+}
+
 // AesEncrypt 加密
 func AesEncrypt(orig, key []byte) ([]byte, error) {
 	// 分组秘钥
@@ -36,6 +46,9 @@ func AesDecrypt(crypt, key []byte) ([]byte, error) {
 	}
 	// 获取秘钥块的长度
 	blockSize := block.BlockSize()
+	if len(crypt) == 0 || len(crypt)%blockSize != 0 {
+		return nil, fmt.Errorf("crypt length %d is not a multiple of the block size", len(crypt))
+	}
 	// 加密模式
 	blockMode := cipher.NewCBCDecrypter(block, key[:blockSize])
 	// 创建数组
@@ -43,8 +56,7 @@ func AesDecrypt(crypt, key []byte) ([]byte, error) {
 	// 解密
 	blockMode.CryptBlocks(orig, crypt)
 	// 去补全码
-	orig = pKCS7UnPadding(orig)
-	return orig, nil
+	return pKCS7UnPadding(orig, blockSize)
 }
 
 //pKCS7Padding 补码
@@ -55,8 +67,11 @@ func pKCS7Padding(orig []byte, blockSize int) []byte {
 }
 
 //pKCS7UnPadding 去码
-func pKCS7UnPadding(origData []byte) []byte {
+func pKCS7UnPadding(origData []byte, blockSize int) ([]byte, error) {
 	length := len(origData)
 	unPadding := int(origData[length-1])
-	return origData[:(length - unPadding)]
+	if unPadding == 0 || unPadding > blockSize || unPadding > length {
+		return nil, fmt.Errorf("invalid padding")
+	}
+	return origData[:(length - unPadding)], nil
 }
@@ -12,3 +12,22 @@ func TestAesEncrypt(t *testing.T) {
 	}
 	t.Log(Base64EncryptBytes(encrypt))
 }
+
+func TestAesDecrypt(t *testing.T) {
+	key := AesKey([]byte("access key id"))
+	encrypt, err := AesEncrypt([]byte(`{"phone":"15166077180"}`), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypt, err := AesDecrypt(encrypt, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypt) != `{"phone":"15166077180"}` {
+		t.Fatal(string(decrypt))
+	}
+	//错误的密文不能panic
+	if _, err = AesDecrypt([]byte("123"), key); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -1,13 +1,18 @@
 package fileServer
 
 import (
+	"context"
 	"fmt"
 	"github.com/qiaojun2016/basic/color"
+	"github.com/qiaojun2016/basic/health"
 	"github.com/qiaojun2016/basic/ip"
+	"github.com/qiaojun2016/basic/tlsServer"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"strings"
 )
 
@@ -20,6 +25,8 @@ type (
 		Block      bool //当主协程能自己维持，block不用开启
 		Endpoint   string
 		BucketName string
+		TLS        *tlsServer.TLS //证书配置，为空时监听http
+		IpResolver *ip.Resolver   //客户端ip，为空时使用 ip.DefaultResolver
 	}
 	server struct {
 		storagePath string
@@ -32,6 +39,45 @@ func (s server) PutSignFileIdURL(fId string) (url string) {
 	return fmt.Sprintf("%s/%s", s.address, fId)
 }
 
+// Save 保存文件流，实现 route.Storage，返回下载地址。读取出错时删除文件
+func (s server) Save(ctx context.Context, key, contentType string, r io.Reader) (url string, err error) {
+	filePath := path.Join(s.storagePath, path.Clean("/"+key))
+	if err = os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+		log.Println(err)
+		return
+	}
+	file, err := os.Create(filePath)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+		if err != nil {
+			_ = os.Remove(filePath)
+		}
+	}()
+	//ctx 取消时停止写入
+	if _, err = io.Copy(file, readerCtx{ctx: ctx, r: r}); err != nil {
+		log.Println(err)
+		return
+	}
+	return fmt.Sprintf("%s/image%s", s.address, path.Clean("/"+key)), nil
+}
+
+// readerCtx ctx 取消时读取返回 ctx 的错误
+type readerCtx struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r readerCtx) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
 func (s Server) Run() {
 	//防止多次创建
 	if FileServer != nil {
@@ -43,6 +89,10 @@ func (s Server) Run() {
 		return
 	}
 	storagePath := fmt.Sprintf("%s/%s", home, s.BucketName)
+	resolver := s.IpResolver
+	if resolver == nil {
+		resolver = ip.DefaultResolver
+	}
 
 	//上传
 	upload := func(w http.ResponseWriter, r *http.Request) {
@@ -83,14 +133,14 @@ func (s Server) Run() {
 		bodyByte, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			errStr := fmt.Sprintf("%s : %s\n", r.URL.Path, "读取body错误")
-			log.Println(errStr)
+			log.Println(resolver.ClientIp(r), errStr)
 			http.Error(w, errStr, http.StatusBadRequest)
 			return
 		}
 
 		if len(bodyByte) == 0 {
 			errStr := fmt.Sprintf("%s : %s\n", r.URL.Path, "没有数据")
-			log.Println(errStr)
+			log.Println(resolver.ClientIp(r), errStr)
 			http.Error(w, errStr, http.StatusBadRequest)
 			return
 		}
@@ -131,9 +181,12 @@ func (s Server) Run() {
 	mux.HandleFunc("/", upload)
 	mux.HandleFunc("/image/", download)
 
+	listener := &health.Listener{}
+	health.Register("fileServer", listener)
 	go func() {
-		err := http.ListenAndServe(s.Endpoint, mux)
+		err := tlsServer.ListenAndServe(&http.Server{Addr: s.Endpoint, Handler: mux}, s.TLS)
 		if err != nil {
+			listener.Fail(err)
 			log.Fatalln(color.Red, err, color.Reset)
 			return
 		}
@@ -153,7 +206,7 @@ func (s Server) Run() {
 	//创建对象
 	FileServer = &server{
 		storagePath: storagePath,
-		address:     fmt.Sprintf("http://%s%s", ips[0], s.Endpoint),
+		address:     fmt.Sprintf("%s://%s%s", s.TLS.Scheme(), ips[0], s.Endpoint),
 	}
 
 	color.Success(fmt.Sprintf(
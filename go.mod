@@ -10,6 +10,7 @@ require (
 	github.com/alibabacloud-go/tea v1.1.17
 	github.com/aliyun/alibaba-cloud-sdk-go v1.61.1628
 	github.com/aliyun/aliyun-oss-go-sdk v2.2.4+incompatible
+	github.com/andybalholm/brotli v1.0.4
 	github.com/bwmarrin/snowflake v0.3.0
 	github.com/chromedp/chromedp v0.8.2
 	github.com/dgraph-io/badger/v3 v3.2103.2
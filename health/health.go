@@ -0,0 +1,171 @@
+// Package health 健康检查，组件启动后注册检查，/healthz 判断进程存活，/readyz 汇总所有检查
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+//返回数据
+/*
+GET /readyz  所有检查通过时200，否则503
+{
+	"status":"ok",
+	"checks":{
+		"mysql":{"status":"ok","latencyMs":1.2},
+		"redis":{"status":"error","error":"dial tcp: connection refused","latencyMs":3}
+	}
+}
+GET /healthz 进程存活时200，不执行检查
+*/
+
+const (
+	// Timeout 一次检查的默认超时
+	Timeout = 3 * time.Second
+
+	statusOK    = "ok"
+	statusError = "error"
+)
+
+type (
+	// Checker 组件的检查，返回nil时正常
+	Checker interface {
+		Check(ctx context.Context) error
+	}
+
+	// CheckerFunc 函数形式的 Checker
+	CheckerFunc func(ctx context.Context) error
+
+	// Result 一个检查的结果
+	Result struct {
+		Status    string  `json:"status"`
+		Error     string  `json:"error,omitempty"`
+		LatencyMs float64 `json:"latencyMs"`
+	}
+
+	// Listener 服务监听的检查，监听失败或关闭时调用 Fail
+	Listener struct {
+		mu  sync.RWMutex
+		err error
+	}
+
+	// Report 所有检查的结果
+	Report struct {
+		Status string            `json:"status"`
+		Checks map[string]Result `json:"checks"`
+	}
+)
+
+var (
+	mu       sync.RWMutex
+	checkers = map[string]Checker{}
+)
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Fail 之后检查返回 err
+func (l *Listener) Fail(err error) {
+	l.mu.Lock()
+	l.err = err
+	l.mu.Unlock()
+}
+
+func (l *Listener) Check(ctx context.Context) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.err
+}
+
+// Register 注册检查，同名的检查会被替换。mysql、redis、badger、mqtt、ws、http 启动后自动注册
+func Register(name string, checker Checker) {
+	mu.Lock()
+	checkers[name] = checker
+	mu.Unlock()
+}
+
+// Unregister 删除检查
+func Unregister(name string) {
+	mu.Lock()
+	delete(checkers, name)
+	mu.Unlock()
+}
+
+// Names 已注册的检查名称
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(checkers))
+	for name := range checkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check 并发执行所有检查，ctx 没有截止时间时每个检查最多 Timeout
+func Check(ctx context.Context) Report {
+	mu.RLock()
+	all := make(map[string]Checker, len(checkers))
+	for name, checker := range checkers {
+		all[name] = checker
+	}
+	mu.RUnlock()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, Timeout)
+		defer cancel()
+	}
+	report := Report{Status: statusOK, Checks: make(map[string]Result, len(all))}
+	var wg sync.WaitGroup
+	var resultMu sync.Mutex
+	for name, checker := range all {
+		wg.Add(1)
+		go func(name string, checker Checker) {
+			defer wg.Done()
+			start := time.Now()
+			err := checker.Check(ctx)
+			result := Result{Status: statusOK, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				result.Status = statusError
+				result.Error = err.Error()
+			}
+			resultMu.Lock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = statusError
+			}
+			resultMu.Unlock()
+		}(name, checker)
+	}
+	wg.Wait()
+	return report
+}
+
+// Healthz 存活检查，进程能处理请求即返回200
+func Healthz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	})
+}
+
+// Readyz 就绪检查，所有检查通过时返回200，否则返回503
+func Readyz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := Check(r.Context())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status != statusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
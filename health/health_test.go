@@ -0,0 +1,45 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyz(t *testing.T) {
+	listener := &Listener{}
+	Register("listener", listener)
+	Register("slow", CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	defer Unregister("listener")
+	defer Unregister("slow")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	report := Check(ctx)
+	if report.Status != statusError || report.Checks["listener"].Status != statusOK || report.Checks["slow"].Error == "" {
+		t.Fatal(report)
+	}
+
+	Unregister("slow")
+	w := httptest.NewRecorder()
+	Readyz().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	listener.Fail(fmt.Errorf("closed"))
+	w = httptest.NewRecorder()
+	Readyz().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusServiceUnavailable || report.Checks["listener"].Error != "closed" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/id"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+//访问日志，每个请求一行json
+/*
+{"time":"2022-06-01T12:00:00.000000001+08:00","level":"info","request_id":"...","method":"POST","pattern":"POST /order",
+"status":200,"latency_ms":1.25,"id":"caller","ip":"127.0.0.1","bytes":56,"cache_hit":false,"error":"handle返回的错误"}
+*/
+
+// LogLevel 访问日志级别
+type LogLevel int
+
+const (
+	// LogOff 不输出
+	LogOff LogLevel = iota
+	// LogError 只输出状态码5xx的请求
+	LogError
+	// LogWarn 输出状态码4xx、5xx的请求
+	LogWarn
+	// LogInfo 输出所有请求
+	LogInfo
+)
+
+type (
+	accessEntry struct {
+		Time      string  `json:"time"`
+		Level     string  `json:"level"`
+		RequestId string  `json:"request_id"`
+		Method    string  `json:"method"`
+		Pattern   string  `json:"pattern"`
+		Status    int     `json:"status"`
+		LatencyMs float64 `json:"latency_ms"`
+		Id        string  `json:"id,omitempty"` //调用者id，认证的路由才有
+		Ip        string  `json:"ip"`
+		Bytes     int     `json:"bytes"`
+		CacheHit  bool    `json:"cache_hit"`
+		Error     string  `json:"error,omitempty"`
+	}
+
+	// accessLogger 并发写同一个 io.Writer
+	accessLogger struct {
+		level LogLevel
+		mu    sync.Mutex
+		enc   *json.Encoder
+	}
+)
+
+func newAccessLogger(w io.Writer, level LogLevel) *accessLogger {
+	if w == nil {
+		w = os.Stdout
+	}
+	return &accessLogger{
+		level: level,
+		enc:   json.NewEncoder(w),
+	}
+}
+
+// log 按级别输出一次请求
+func (l *accessLogger) log(c *Context, w *statusWriter, start time.Time) {
+	if l.level == LogOff {
+		return
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	level := "info"
+	switch {
+	case status >= 500:
+		level = "error"
+	case status >= 400:
+		level = "warn"
+		if l.level < LogWarn {
+			return
+		}
+	default:
+		if l.level < LogInfo {
+			return
+		}
+	}
+	entry := accessEntry{
+		Time:      start.Format(time.RFC3339Nano),
+		Level:     level,
+		RequestId: c.RequestId,
+		Method:    c.Request.Method,
+		Pattern:   c.Pattern,
+		Status:    status,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		Ip:        c.RealIp,
+		Bytes:     w.bytes,
+		CacheHit:  c.CacheHit,
+	}
+	if c.Id != 0 {
+		entry.Id = id.SId.ToString(c.Id)
+	}
+	if c.Err != nil {
+		entry.Error = c.Err.Error()
+	}
+	l.mu.Lock()
+	if err := l.enc.Encode(entry); err != nil {
+		log.Println(err)
+	}
+	l.mu.Unlock()
+}
+
+// recovery 处理handle中的panic，输出堆栈，还没有写出时返回500
+func recovery(c *Context, w *statusWriter, p interface{}) {
+	if p == http.ErrAbortHandler {
+		//net/http 约定的中断，交给 http.Server 处理
+		panic(p)
+	}
+	log.Printf("%s %s panic: %v\n%s", c.RequestId, c.Pattern, p, debug.Stack())
+	c.Err = fmt.Errorf("panic: %v", p)
+	if w.status == 0 {
+		c.AbortWithError(http.StatusInternalServerError, NewError(CodeInternal, "internal", "服务内部错误"))
+	}
+}
@@ -0,0 +1,46 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/qiaojun2016/basic/http/route"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_recovery(t *testing.T) {
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	RegisterTyped(Route{
+		Method:  http.MethodPost,
+		Url:     "/test/panic",
+		Pattern: Pattern{Auth: AuthDisable},
+	}, func(c *Context, req map[string]interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	var buf bytes.Buffer
+	mux := Server{MaxPayloadBytes: 1 << 20, AccessLog: &buf, AccessLogLevel: LogError}.mux(nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/test/panic", strings.NewReader(`{"t":""}`))
+	r.Header.Set("X-Request-Id", "req-1")
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError || w.Header().Get("X-Request-Id") != "req-1" {
+		t.Fatal(w.Code, w.Header())
+	}
+	var body response
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == nil || body.Error.Code != CodeInternal {
+		t.Fatal(w.Body.String())
+	}
+
+	var entry accessEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if entry.Level != "error" || entry.Status != 500 || entry.Pattern != "POST /test/panic" || entry.RequestId != "req-1" ||
+		entry.Error != "panic: boom" || entry.Bytes != w.Body.Len() {
+		t.Fatal(buf.String())
+	}
+}
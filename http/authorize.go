@@ -0,0 +1,161 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/mysql"
+	"github.com/qiaojun2016/basic/redis"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//角色和权限
+/*
+Pattern.Roles       需要的角色，满足其一即可
+Pattern.Permissions 需要的权限，需要全部满足
+在 Auth、Signature 之后由 Server.RoleResolver 根据令牌中的id得到 Grant，不满足时返回403，不执行handle
+令牌带有服务端密钥的签名（见 token.Server），客户端不能修改其中的id
+redis 中的结构：
+basic:role:{id}       用户的角色集合
+basic:permission:{id} 用户的权限集合
+*/
+
+const (
+	rolePrefix       = "basic:role:"
+	permissionPrefix = "basic:permission:"
+)
+
+type (
+	// RoleResolver 根据令牌中的id得到用户的角色和权限
+	RoleResolver interface {
+		Resolve(ctx context.Context, id int64) (Grant, error)
+	}
+
+	// RoleResolverFunc 函数形式的 RoleResolver
+	RoleResolverFunc func(ctx context.Context, id int64) (Grant, error)
+
+	// RedisRoleResolver 从redis的集合中读取角色和权限
+	RedisRoleResolver struct{}
+
+	// MysqlRoleResolver 从mysql读取角色和权限，查询语句的参数为id，返回一列字符串
+	MysqlRoleResolver struct {
+		RoleQuery       string //如 SELECT role FROM user_role WHERE user_id = ?
+		PermissionQuery string //为空时不查询权限
+	}
+
+	// CachedRoleResolver 在进程内缓存 Resolve 的结果
+	CachedRoleResolver struct {
+		resolver RoleResolver
+		ttl      time.Duration
+		mu       sync.RWMutex
+		grants   map[int64]cachedGrant
+	}
+
+	cachedGrant struct {
+		grant   Grant
+		expires time.Time
+	}
+)
+
+func (f RoleResolverFunc) Resolve(ctx context.Context, id int64) (Grant, error) {
+	return f(ctx, id)
+}
+
+func (RedisRoleResolver) Resolve(ctx context.Context, id int64) (Grant, error) {
+	if redis.Redis == nil {
+		return Grant{}, fmt.Errorf("redis not run")
+	}
+	client := redis.Redis.Client()
+	roles, err := client.SMembers(ctx, fmt.Sprintf("%s%d", rolePrefix, id)).Result()
+	if err != nil {
+		return Grant{}, err
+	}
+	permissions, err := client.SMembers(ctx, fmt.Sprintf("%s%d", permissionPrefix, id)).Result()
+	if err != nil {
+		return Grant{}, err
+	}
+	return Grant{Roles: roles, Permissions: permissions}, nil
+}
+
+func (r MysqlRoleResolver) Resolve(ctx context.Context, id int64) (Grant, error) {
+	if mysql.Mysql == nil {
+		return Grant{}, fmt.Errorf("mysql not run")
+	}
+	db := mysql.GetDb()
+	var grant Grant
+	if err := db.SelectContext(ctx, &grant.Roles, r.RoleQuery, id); err != nil {
+		return Grant{}, err
+	}
+	if r.PermissionQuery != "" {
+		if err := db.SelectContext(ctx, &grant.Permissions, r.PermissionQuery, id); err != nil {
+			return Grant{}, err
+		}
+	}
+	return grant, nil
+}
+
+// NewCachedRoleResolver 缓存 resolver 的结果 ttl 时间，角色变化后调用 Invalidate
+func NewCachedRoleResolver(resolver RoleResolver, ttl time.Duration) *CachedRoleResolver {
+	return &CachedRoleResolver{
+		resolver: resolver,
+		ttl:      ttl,
+		grants:   make(map[int64]cachedGrant),
+	}
+}
+
+func (r *CachedRoleResolver) Resolve(ctx context.Context, id int64) (Grant, error) {
+	now := time.Now()
+	r.mu.RLock()
+	cached, ok := r.grants[id]
+	r.mu.RUnlock()
+	if ok && now.Before(cached.expires) {
+		return cached.grant, nil
+	}
+	grant, err := r.resolver.Resolve(ctx, id)
+	if err != nil {
+		return Grant{}, err
+	}
+	r.mu.Lock()
+	//顺便清除过期的缓存
+	for k, v := range r.grants {
+		if !now.Before(v.expires) {
+			delete(r.grants, k)
+		}
+	}
+	r.grants[id] = cachedGrant{grant: grant, expires: now.Add(r.ttl)}
+	r.mu.Unlock()
+	return grant, nil
+}
+
+// Invalidate 清除一个用户的缓存
+func (r *CachedRoleResolver) Invalidate(id int64) {
+	r.mu.Lock()
+	delete(r.grants, id)
+	r.mu.Unlock()
+}
+
+// Authorize 校验角色和权限，在 Signature 之后执行
+func Authorize(resolver RoleResolver) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			p := c.Route.Pattern
+			if len(p.Roles) == 0 && len(p.Permissions) == 0 {
+				next(c)
+				return
+			}
+			grant, err := resolver.Resolve(c.Context(), c.Id)
+			if err != nil {
+				c.Abort(http.StatusInternalServerError, fmt.Sprintf("%s : %s", c.Pattern, err))
+				return
+			}
+			if !grant.Allow(p) {
+				c.AbortWithError(http.StatusForbidden, ErrForbidden.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "没有权限")))
+				return
+			}
+			c.Grant = grant
+			next(c)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package http
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/qiaojun2016/basic/cipher"
+	"github.com/qiaojun2016/basic/http/client"
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/id"
+	"github.com/qiaojun2016/basic/token"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGrant_Allow(t *testing.T) {
+	g := Grant{Roles: []string{"editor"}, Permissions: []string{"order.read", "order.write"}}
+	for _, c := range []struct {
+		p    Pattern
+		want bool
+	}{
+		{Pattern{Roles: []string{"admin", "editor"}}, true},
+		{Pattern{Roles: []string{"admin"}}, false},
+		{Pattern{Permissions: []string{"order.read", "order.write"}}, true},
+		{Pattern{Roles: []string{"editor"}, Permissions: []string{"order.delete"}}, false},
+	} {
+		if got := g.Allow(c.p); got != c.want {
+			t.Errorf("%v: got %v", c.p.Roles, got)
+		}
+	}
+}
+
+func TestCachedRoleResolver(t *testing.T) {
+	calls := 0
+	r := NewCachedRoleResolver(RoleResolverFunc(func(ctx context.Context, id int64) (Grant, error) {
+		calls++
+		return Grant{Roles: []string{"admin"}}, nil
+	}), time.Minute)
+	for i := 0; i < 3; i++ {
+		if g, err := r.Resolve(context.Background(), 1); err != nil || !g.HasRole("admin") {
+			t.Fatal(g, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("calls = %d", calls)
+	}
+	r.Invalidate(1)
+	_, _ = r.Resolve(context.Background(), 1)
+	if calls != 2 {
+		t.Fatalf("calls = %d after invalidate", calls)
+	}
+}
+
+func TestAuthorize_forgedToken(t *testing.T) {
+	id.Server{Node: 1}.Run()
+	//需要角色的路由会让没有 RoleResolver 的 mux 失败，测试结束时删除
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	RegisterTyped(Route{
+		Method:  http.MethodPost,
+		Url:     "/test/authorize/admin",
+		Pattern: Pattern{Auth: Enable, Roles: []string{"admin"}},
+	}, func(c *Context, req map[string]interface{}) (int64, error) {
+		return c.Id, nil
+	})
+	//只有 id 1 是管理员
+	resolver := RoleResolverFunc(func(ctx context.Context, id int64) (Grant, error) {
+		if id == 1 {
+			return Grant{Roles: []string{"admin"}}, nil
+		}
+		return Grant{}, nil
+	})
+	srv := httptest.NewServer(Server{MaxPayloadBytes: 1 << 20, RoleResolver: resolver}.mux(nil))
+	defer srv.Close()
+	call := func(t string) int {
+		c := client.New(srv.URL)
+		if err := c.SetToken(t); err != nil {
+			return -1
+		}
+		_, err := c.Do(context.Background(), client.Request{Path: "/test/authorize/admin"})
+		var statusErr *client.StatusError
+		if errors.As(err, &statusErr) {
+			return statusErr.Code
+		}
+		if err != nil {
+			return -1
+		}
+		return CodeOK
+	}
+	user := token.Token{Id: 2}
+	s := user.Encode()
+	if got := call(s); got != CodeForbidden {
+		t.Fatal(got)
+	}
+	//把令牌中的id改为管理员，没有密钥无法重新签名
+	bs, _ := cipher.Base64DecryptBytes(s)
+	binary.LittleEndian.PutUint64(bs[8:16], 1)
+	if got := call(cipher.Base64EncryptBytes(bs)); got != CodeTokenInvalid {
+		t.Fatal("forged admin token", got)
+	}
+	admin := token.Token{Id: 1}
+	if got := call(admin.Encode()); got != CodeOK {
+		t.Fatal(got)
+	}
+}
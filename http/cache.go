@@ -0,0 +1,410 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/redis"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//缓存结构
+/*
+basic:cache:{pattern}:{md5(参数)} 缓存的结果，Pattern.CacheExpire 大于0时按秒过期
+basic:cache:zindex:{pattern}      路由下所有缓存的key，有序集合，score 为过期时间（毫秒），写入时清除已过期的key，用于按路由清除
+存储见 CacheStore，默认为redis，测试时可以使用 MemoryCacheStore
+参数为去掉 t、d、v、e、ts、n 并合并路径参数之后按key排序的json，与令牌、设备无关
+*/
+
+const cachePrefix = "basic:cache:"
+
+type (
+	// CacheStore 保存路由的缓存，index 为路由的索引，用于按路由清除
+	CacheStore interface {
+		// Get 读取缓存，不存在或已过期时返回错误
+		Get(ctx context.Context, key string) ([]byte, error)
+		// Set 写入缓存并加入索引，exp 为0时不过期
+		Set(ctx context.Context, index, key string, value []byte, exp time.Duration) error
+		// Delete 删除缓存并移出索引
+		Delete(ctx context.Context, index, key string) error
+		// DeleteIndex 删除索引和索引中的所有缓存
+		DeleteIndex(ctx context.Context, index string) error
+	}
+
+	// RedisCacheStore 使用redis保存，多个实例共享，默认的存储
+	RedisCacheStore struct{}
+
+	// MemoryCacheStore 保存在进程内，用于测试和单实例
+	MemoryCacheStore struct {
+		mu        sync.Mutex
+		items     map[string]memoryItem
+		indexes   map[string]map[string]struct{}
+		nextSweep time.Time //写入时超过该时间清理过期的缓存
+	}
+
+	memoryItem struct {
+		value  []byte
+		index  string
+		expire time.Time //为零时不过期
+	}
+
+	// CacheStat 路由的缓存统计
+	CacheStat struct {
+		Hit         int64 //命中缓存
+		Miss        int64 //未命中缓存，执行了handle
+		Penetration int64 //未命中且handle出错，结果无法缓存，每次都会穿透到handle
+	}
+
+	cacheCounter struct {
+		hit         int64
+		miss        int64
+		penetration int64
+	}
+)
+
+var (
+	cacheCounters sync.Map
+
+	errRedisNotRun = fmt.Errorf("redis not run")
+	errCacheMiss   = fmt.Errorf("cache miss")
+)
+
+// cacheStore 缓存的存储，Cache 中间件和 Invalidate* 共用，为空时使用redis
+func (h Server) cacheStore() CacheStore {
+	if h.CacheStore == nil {
+		return RedisCacheStore{}
+	}
+	return h.CacheStore
+}
+
+func (RedisCacheStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if redis.Redis == nil {
+		return nil, errRedisNotRun
+	}
+	return redis.Redis.GetCtx(ctx, key)
+}
+
+func (RedisCacheStore) Set(ctx context.Context, index, key string, value []byte, exp time.Duration) error {
+	if redis.Redis == nil {
+		return errRedisNotRun
+	}
+	now := time.Now()
+	score := math.Inf(1)
+	if exp > 0 {
+		score = float64(now.Add(exp).UnixMilli())
+	}
+	pipe := redis.Redis.Client().TxPipeline()
+	pipe.Set(ctx, key, value, exp)
+	pipe.ZAdd(ctx, index, &goredis.Z{Score: score, Member: key})
+	//已过期的key不再留在索引中
+	pipe.ZRemRangeByScore(ctx, index, "-inf", fmt.Sprintf("(%d", now.UnixMilli()))
+	if exp > 0 {
+		//索引至少和最新的缓存存活一样久
+		pipe.Expire(ctx, index, exp)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (RedisCacheStore) Delete(ctx context.Context, index, key string) error {
+	if redis.Redis == nil {
+		return errRedisNotRun
+	}
+	pipe := redis.Redis.Client().TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.ZRem(ctx, index, key)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (RedisCacheStore) DeleteIndex(ctx context.Context, index string) error {
+	if redis.Redis == nil {
+		return errRedisNotRun
+	}
+	keys, err := redis.Redis.Client().ZRange(ctx, index, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	_, err = redis.Redis.Del(append(keys, index)...)
+	return err
+}
+
+// NewMemoryCacheStore 创建进程内的存储
+func NewMemoryCacheStore() *MemoryCacheStore {
+	return &MemoryCacheStore{items: map[string]memoryItem{}, indexes: map[string]map[string]struct{}{}}
+}
+
+func (s *MemoryCacheStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	if !ok {
+		return nil, errCacheMiss
+	}
+	if item.expired(time.Now()) {
+		s.remove(key, item)
+		return nil, errCacheMiss
+	}
+	return item.value, nil
+}
+
+func (item memoryItem) expired(now time.Time) bool {
+	return !item.expire.IsZero() && now.After(item.expire)
+}
+
+// remove 删除缓存并移出索引
+func (s *MemoryCacheStore) remove(key string, item memoryItem) {
+	delete(s.items, key)
+	if keys, ok := s.indexes[item.index]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(s.indexes, item.index)
+		}
+	}
+}
+
+// sweep 每分钟最多一次，清理所有过期的缓存
+func (s *MemoryCacheStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	s.nextSweep = now.Add(time.Minute)
+	for key, item := range s.items {
+		if item.expired(now) {
+			s.remove(key, item)
+		}
+	}
+}
+
+func (s *MemoryCacheStore) Set(ctx context.Context, index, key string, value []byte, exp time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	item := memoryItem{value: value, index: index}
+	if exp > 0 {
+		item.expire = now.Add(exp)
+	}
+	s.items[key] = item
+	if s.indexes[index] == nil {
+		s.indexes[index] = map[string]struct{}{}
+	}
+	s.indexes[index][key] = struct{}{}
+	return nil
+}
+
+func (s *MemoryCacheStore) Delete(ctx context.Context, index, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.items[key]; ok {
+		s.remove(key, item)
+	}
+	delete(s.indexes[index], key)
+	return nil
+}
+
+func (s *MemoryCacheStore) DeleteIndex(ctx context.Context, index string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.indexes[index] {
+		delete(s.items, key)
+	}
+	delete(s.indexes, index)
+	return nil
+}
+
+// Len 未过期的缓存个数
+func (s *MemoryCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	now := time.Now()
+	for _, item := range s.items {
+		if !item.expired(now) {
+			n++
+		}
+	}
+	return n
+}
+
+func counter(pattern string) *cacheCounter {
+	c, _ := cacheCounters.LoadOrStore(pattern, &cacheCounter{})
+	return c.(*cacheCounter)
+}
+
+// CacheStats 返回各路由的缓存统计
+func CacheStats() map[string]CacheStat {
+	stats := make(map[string]CacheStat)
+	cacheCounters.Range(func(key, value interface{}) bool {
+		c := value.(*cacheCounter)
+		stats[key.(string)] = CacheStat{
+			Hit:         atomic.LoadInt64(&c.hit),
+			Miss:        atomic.LoadInt64(&c.miss),
+			Penetration: atomic.LoadInt64(&c.penetration),
+		}
+		return true
+	})
+	return stats
+}
+
+// cacheParam 去掉认证字段，合并路径参数，返回与令牌无关的参数
+func cacheParam(params map[string]string, param []byte) (string, error) {
+	m := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(param))
+	decoder.UseNumber()
+	if err := decoder.Decode(&m); err != nil {
+		return "", err
+	}
+	delete(m, "t")
+	delete(m, "d")
+	delete(m, "v")
+	delete(m, "e")
+	delete(m, "ts")
+	delete(m, "n")
+	for k, v := range params {
+		m[k] = v
+	}
+	//map会按key排序
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func cacheIndexKey(pattern string) string {
+	return fmt.Sprintf("%szindex:%s", cachePrefix, pattern)
+}
+
+func cacheKey(pattern string, params map[string]string, param []byte) (string, error) {
+	p, err := cacheParam(params, param)
+	if err != nil {
+		return "", err
+	}
+	sum := md5.Sum([]byte(p))
+	return fmt.Sprintf("%s%s:%s", cachePrefix, pattern, hex.EncodeToString(sum[:])), nil
+}
+
+func cache(store CacheStore, c *Context) {
+	key, err := cacheKey(c.Pattern, c.Params, c.Data)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	exp := time.Duration(c.Route.Pattern.CacheExpire) * time.Second
+	if err = store.Set(context.Background(), cacheIndexKey(c.Pattern), key, c.Body, exp); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+func getCache(store CacheStore, c *Context) (result []byte, err error) {
+	cc := counter(c.Pattern)
+	key, err := cacheKey(c.Pattern, c.Params, c.Data)
+	if err == nil {
+		result, err = store.Get(c.Context(), key)
+	}
+	if err != nil {
+		if err == errRedisNotRun {
+			log.Println(err)
+		}
+		atomic.AddInt64(&cc.miss, 1)
+		cacheTotal.Inc(c.Pattern, "miss")
+		return
+	}
+	atomic.AddInt64(&cc.hit, 1)
+	cacheTotal.Inc(c.Pattern, "hit")
+	return
+}
+
+// cachePenetrate 记录一次无法缓存的穿透
+func cachePenetrate(pattern string) {
+	atomic.AddInt64(&counter(pattern).penetration, 1)
+	cacheTotal.Inc(pattern, "penetration")
+}
+
+// patterns 路由所有版本的缓存使用的 Route.Key，key 为不带版本范围的key，如 "POST /order"，
+// 不是 Versions 中的key时按单个路由处理
+func patterns(key string) []string {
+	versions, ok := Versions()[key]
+	if !ok {
+		return []string{key}
+	}
+	keys := make([]string, len(versions))
+	for i, route := range versions {
+		keys[i] = route.Key()
+	}
+	return keys
+}
+
+// InvalidateRoute 清除一个路由所有版本的全部缓存，key 为不带版本范围的key，如 "POST /order"。
+// 使用 Server.CacheStore，与运行的服务使用同样配置的 Server 调用
+func (h Server) InvalidateRoute(key string) error {
+	for _, pattern := range patterns(key) {
+		if err := h.invalidatePattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h Server) invalidatePattern(pattern string) error {
+	if err := h.cacheStore().DeleteIndex(context.Background(), cacheIndexKey(pattern)); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// InvalidateParam 清除一个路由所有版本下指定参数的缓存，key 同 InvalidateRoute，
+// param 为请求的业务参数（包含路径参数），可以是结构体、map或json
+func (h Server) InvalidateParam(key string, param interface{}) error {
+	var b []byte
+	switch value := param.(type) {
+	case []byte:
+		b = value
+	case string:
+		b = []byte(value)
+	default:
+		var err error
+		if b, err = json.Marshal(param); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range patterns(key) {
+		cacheK, err := cacheKey(pattern, nil, b)
+		if err != nil {
+			return err
+		}
+		if err = h.cacheStore().Delete(context.Background(), cacheIndexKey(pattern), cacheK); err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidateTag 清除 Pattern.CacheTags 中包含 tag 的所有路由的缓存
+func (h Server) InvalidateTag(tag string) error {
+	for pattern, route := range All() {
+		for _, t := range route.Pattern.CacheTags {
+			if t != tag {
+				continue
+			}
+			if err := h.invalidatePattern(pattern); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	. "github.com/qiaojun2016/basic/http/route"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_cacheKey(t *testing.T) {
+	k1, err := cacheKey("/list", nil, []byte(`{"t":"token1","d":"device1","v":1,"page":1,"size":20}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//不同的令牌、设备、字段顺序，同一组参数
+	k2, err := cacheKey("/list", nil, []byte(`{"size":20,"page":1,"t":"token2","d":"device2","v":2,"ts":1650000000,"n":"nonce"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1 != k2 {
+		t.Fatalf("%s != %s", k1, k2)
+	}
+	k3, err := cacheKey("/list", nil, []byte(`{"page":2,"size":20}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1 == k3 {
+		t.Fatal("different params share a key")
+	}
+}
+
+func Test_cacheKey_params(t *testing.T) {
+	k1, err := cacheKey("GET /order/{id}", map[string]string{"id": "1"}, []byte(`{"t":"token"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := cacheKey("GET /order/{id}", map[string]string{"id": "2"}, []byte(`{"t":"token"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1 == k2 {
+		t.Fatal("different path params share a key")
+	}
+	//InvalidateParam 使用合并后的参数
+	k3, err := cacheKey("GET /order/{id}", nil, []byte(`{"id":"1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1 != k3 {
+		t.Fatalf("%s != %s", k1, k3)
+	}
+}
+
+func TestMemoryCacheStore_prune(t *testing.T) {
+	s := NewMemoryCacheStore()
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		_ = s.Set(ctx, "index", fmt.Sprintf("key%d", i), []byte("v"), time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+	//过期的缓存在写入时被清理，索引不会一直增长
+	s.nextSweep = time.Time{}
+	_ = s.Set(ctx, "index", "fresh", []byte("v"), time.Minute)
+	if len(s.items) != 1 || len(s.indexes["index"]) != 1 {
+		t.Fatal(len(s.items), len(s.indexes["index"]))
+	}
+	//读取时发现过期也移出索引
+	_ = s.Set(ctx, "other", "short", []byte("v"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := s.Get(ctx, "short"); err == nil {
+		t.Fatal("expired item returned")
+	}
+	if _, ok := s.indexes["other"]; ok {
+		t.Fatal("index not pruned")
+	}
+}
+
+func TestInvalidateRoute_versions(t *testing.T) {
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	calls := map[string]int{}
+	register := func(p Pattern, name string) {
+		p.Auth = AuthDisable
+		p.Cache = Enable
+		RegisterTyped(Route{Method: http.MethodPost, Url: "/test/cache/version", Pattern: p},
+			func(c *Context, req map[string]interface{}) (string, error) {
+				calls[name]++
+				return name, nil
+			})
+	}
+	register(Pattern{Version: 1, MaxVersion: 1}, "v1")
+	register(Pattern{Version: 2}, "v2")
+	store := NewMemoryCacheStore()
+	server := Server{MaxPayloadBytes: 1 << 20, CacheStore: store}
+	mux := server.mux(nil)
+	//另一个服务使用自己的存储，互不影响
+	other := NewMemoryCacheStore()
+	otherMux := Server{MaxPayloadBytes: 1 << 20, CacheStore: other}.mux(nil)
+	post := func(v int) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/test/cache/version", strings.NewReader(fmt.Sprintf(`{"v":%d,"id":1}`, v))))
+		if w.Code != http.StatusOK {
+			t.Fatal(w.Code, w.Body.String())
+		}
+	}
+	both := func() {
+		post(1)
+		post(2)
+	}
+	both()
+	both()
+	if calls["v1"] != 1 || calls["v2"] != 1 || store.Len() != 2 {
+		t.Fatal(calls, store.Len())
+	}
+	w := httptest.NewRecorder()
+	otherMux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/test/cache/version", strings.NewReader(`{"v":2,"id":1}`)))
+	if w.Code != http.StatusOK || calls["v2"] != 2 || other.Len() != 1 || store.Len() != 2 {
+		t.Fatal(w.Code, calls, other.Len(), store.Len())
+	}
+	//不带版本的key清除所有版本，只清除这个服务的存储
+	if err := server.InvalidateRoute("POST /test/cache/version"); err != nil || store.Len() != 0 || other.Len() != 1 {
+		t.Fatal(err, store.Len(), other.Len())
+	}
+	both()
+	if calls["v1"] != 2 || calls["v2"] != 3 {
+		t.Fatal(calls)
+	}
+	if err := server.InvalidateParam("POST /test/cache/version", map[string]interface{}{"id": 1}); err != nil || store.Len() != 0 {
+		t.Fatal(err, store.Len())
+	}
+}
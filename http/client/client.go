@@ -0,0 +1,543 @@
+// Package client 调用 basic/http 服务的客户端，负责令牌、签名、加密和返回数据的解析
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/qiaojun2016/basic/cipher"
+	"github.com/qiaojun2016/basic/token"
+	"github.com/qiaojun2016/basic/trace"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const contentSign = "Content-Sign"
+
+type (
+	// Client 客户端，可以在多个协程中使用
+	Client struct {
+		BaseURL    string       //服务地址，如 http://127.0.0.1:8080
+		DeviceId   string       //设备id，请求中的 d
+		Version    int64        //客户端版本，请求中的 v
+		UserAgent  string       //服务配置了 UserAgent 时需要
+		HTTPClient *http.Client //为空时使用20秒超时的默认客户端，Stream 不超时
+
+		mu    sync.RWMutex
+		token string
+		ak    []byte
+	}
+
+	// Request 一次请求
+	Request struct {
+		Method  string      //为空时为 POST
+		Path    string      //路由，如 /order/12
+		Param   interface{} //业务参数，结构体或map
+		Encrypt bool        //路由开启了 Pattern.Encrypt
+		NoAuth  bool        //路由关闭了认证，返回数据没有签名，有令牌时也不要求 Content-Sign
+		Files   []File      //上传的文件，不为空时以 multipart/form-data 发送，参数在 data 字段
+	}
+
+	// File 上传的文件，发送前读取一次计算摘要，再从头发送
+	File struct {
+		Field string //表单字段名，同一个请求中不能重复
+		Name  string //文件名
+		Body  io.ReadSeeker
+	}
+
+	// Response 服务返回的 {version,state,data,error}
+	Response struct {
+		Version int64           `json:"version"`
+		State   string          `json:"state"`
+		Data    json.RawMessage `json:"data"`
+		Error   *Error          `json:"error,omitempty"`
+	}
+
+	// Error 服务返回的错误码，见 route.Error
+	Error struct {
+		Code    int             `json:"code"`
+		Key     string          `json:"key"`
+		Message string          `json:"message"`
+		Details json.RawMessage `json:"details,omitempty"`
+	}
+
+	// streamEvent 流式输出的一个事件，见 route.RegisterStream
+	streamEvent struct {
+		Id    int64           `json:"id"`
+		Event string          `json:"event"`
+		Data  json.RawMessage `json:"data"`
+		Sign  string          `json:"sign"`
+	}
+
+	// StatusError 服务返回了非200的状态码，Code、Key 为中断请求的错误码
+	StatusError struct {
+		StatusCode int
+		Body       string
+		Code       int
+		Key        string
+	}
+
+	// StateError 服务返回的 state 不是 OK，Code、Key、Details 为 handle 返回的错误码
+	StateError struct {
+		State   string
+		Code    int
+		Key     string
+		Details json.RawMessage
+	}
+)
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+func (e *StateError) Error() string {
+	return e.State
+}
+
+// New 创建客户端
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// SetToken 设置令牌，同时计算签名用的 AccessKeyID。客户端没有令牌的密钥，只解码不校验
+func (c *Client) SetToken(t string) error {
+	tk := token.Token{}
+	if err := tk.Parse(t); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.token = t
+	c.ak = []byte(tk.AccessKeyID())
+	c.mu.Unlock()
+	return nil
+}
+
+// Token 当前令牌
+func (c *Client) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// Login 调用登录路由并保存令牌，field 为 data 中令牌的字段名，为空时 data 即为令牌
+func (c *Client) Login(ctx context.Context, path string, param interface{}, field string) error {
+	resp, err := c.Do(ctx, Request{Path: path, Param: param})
+	if err != nil {
+		return err
+	}
+	var t string
+	if field == "" {
+		err = json.Unmarshal(resp.Data, &t)
+	} else {
+		var m map[string]json.RawMessage
+		if err = json.Unmarshal(resp.Data, &m); err == nil {
+			err = json.Unmarshal(m[field], &t)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("login: token not found in data: %s", err)
+	}
+	return c.SetToken(t)
+}
+
+// Post 以 POST 调用路由，把 data 解析到 result，result 为 nil 时忽略 data
+func (c *Client) Post(ctx context.Context, path string, param, result interface{}) error {
+	return c.call(ctx, Request{Method: http.MethodPost, Path: path, Param: param}, result)
+}
+
+// Get 以 GET 调用路由，参数放在url中
+func (c *Client) Get(ctx context.Context, path string, param, result interface{}) error {
+	return c.call(ctx, Request{Method: http.MethodGet, Path: path, Param: param}, result)
+}
+
+// Call 调用路由，返回类型化的 data
+func Call[Resp any](ctx context.Context, c *Client, req Request) (Resp, error) {
+	var result Resp
+	err := c.call(ctx, req, &result)
+	return result, err
+}
+
+func (c *Client) call(ctx context.Context, req Request, result interface{}) error {
+	resp, err := c.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	if result == nil || len(resp.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Data, result)
+}
+
+// Do 发送请求，校验签名，state 不是 OK 时返回 *StateError
+func (c *Client) Do(ctx context.Context, req Request) (*Response, error) {
+	body, err := c.raw(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{}
+	if err = json.Unmarshal(body, resp); err != nil {
+		return nil, err
+	}
+	if err = stateError(resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// Raw 发送请求，返回校验签名、解密之后的原始数据，用于通用模式的路由
+func (c *Client) Raw(ctx context.Context, req Request) ([]byte, error) {
+	return c.raw(ctx, req)
+}
+
+func (c *Client) raw(ctx context.Context, req Request) ([]byte, error) {
+	httpResp, ak, err := c.send(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = httpResp.Body.Close()
+	}()
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, statusError(httpResp.StatusCode, body)
+	}
+
+	//校验签名，有令牌时开启认证的路由必须返回签名，缺少签名视为被篡改
+	sig := httpResp.Header.Get(contentSign)
+	if sig == "" && ak != nil && !req.NoAuth {
+		return nil, fmt.Errorf("%s : response signature missing", req.Path)
+	}
+	if sig != "" && (ak == nil || !cipher.CheckSign(sig, body, ak)) {
+		return nil, fmt.Errorf("%s : response signature mismatch", req.Path)
+	}
+	//解密
+	if req.Encrypt {
+		crypt, err := cipher.Base64DecryptBytes(string(body))
+		if err != nil {
+			return nil, err
+		}
+		return cipher.AesDecrypt(crypt, cipher.AesKey(ak))
+	}
+	return body, nil
+}
+
+// Stream 调用流式输出的路由，按顺序把事件交给 fn，fn 返回错误时停止读取。
+// 开启认证的路由校验每个事件的签名，服务端推送的 error 事件返回 *StateError。
+// HTTPClient 的 Timeout 对整个流有效，没有配置时不超时，由 ctx 控制
+func (c *Client) Stream(ctx context.Context, req Request, fn func(event string, data json.RawMessage) error) error {
+	httpResp, ak, err := c.send(ctx, req, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = httpResp.Body.Close()
+	}()
+	if httpResp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(httpResp.Body)
+		return statusError(httpResp.StatusCode, body)
+	}
+	ndjson := strings.HasPrefix(httpResp.Header.Get("Content-Type"), "application/x-ndjson")
+	if !ndjson && !strings.HasPrefix(httpResp.Header.Get("Content-Type"), "text/event-stream") {
+		//开始输出之前出错，返回的是普通的json
+		body, err := ioutil.ReadAll(httpResp.Body)
+		if err != nil {
+			return err
+		}
+		resp := &Response{}
+		if err = json.Unmarshal(body, resp); err != nil {
+			return err
+		}
+		return stateError(resp)
+	}
+
+	dispatch := func(e streamEvent) error {
+		if e.Data == nil {
+			return nil
+		}
+		if e.Sign == "" && ak != nil && !req.NoAuth {
+			return fmt.Errorf("%s : event %d signature missing", req.Path, e.Id)
+		}
+		if e.Sign != "" && (ak == nil || !cipher.CheckSign(e.Sign, e.Data, ak)) {
+			return fmt.Errorf("%s : event %d signature mismatch", req.Path, e.Id)
+		}
+		if e.Event == "error" {
+			var routeErr Error
+			_ = json.Unmarshal(e.Data, &routeErr)
+			return &StateError{State: routeErr.Message, Code: routeErr.Code, Key: routeErr.Key, Details: routeErr.Details}
+		}
+		return fn(e.Event, e.Data)
+	}
+	scanner := bufio.NewScanner(httpResp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	var e streamEvent
+	for scanner.Scan() {
+		line := scanner.Text()
+		if ndjson {
+			if line == "" {
+				//心跳
+				continue
+			}
+			e = streamEvent{}
+			if err = json.Unmarshal([]byte(line), &e); err != nil {
+				return err
+			}
+			if err = dispatch(e); err != nil {
+				return err
+			}
+			continue
+		}
+		//SSE，空行结束一个事件，: 开头为心跳
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "":
+			if line == "" {
+				if err = dispatch(e); err != nil {
+					return err
+				}
+				e = streamEvent{}
+			}
+		case "id":
+			e.Id, _ = strconv.ParseInt(value, 10, 64)
+		case "event":
+			e.Event = value
+		case "sign":
+			e.Sign = value
+		case "data":
+			e.Data = append(e.Data, value...)
+		}
+	}
+	return scanner.Err()
+}
+
+// stateError state 不是 OK 时的错误
+func stateError(resp *Response) error {
+	if resp.State == "OK" {
+		return nil
+	}
+	stateErr := &StateError{State: resp.State}
+	if resp.Error != nil {
+		stateErr.Code = resp.Error.Code
+		stateErr.Key = resp.Error.Key
+		stateErr.Details = resp.Error.Details
+	}
+	return stateErr
+}
+
+// statusError 非200的返回，中断请求的body为带错误码的json
+func statusError(status int, body []byte) *StatusError {
+	statusErr := &StatusError{StatusCode: status, Body: string(body)}
+	var resp Response
+	if json.Unmarshal(body, &resp) == nil && resp.Error != nil {
+		statusErr.Code = resp.Error.Code
+		statusErr.Key = resp.Error.Key
+	}
+	return statusErr
+}
+
+// send 签名并发送请求，返回令牌的AccessKeyID用于校验返回数据。stream 为 true 时默认客户端不设置超时
+func (c *Client) send(ctx context.Context, req Request, stream bool) (*http.Response, []byte, error) {
+	c.mu.RLock()
+	t, ak := c.token, c.ak
+	c.mu.RUnlock()
+	if req.Encrypt && ak == nil {
+		return nil, nil, fmt.Errorf("encrypt requires token")
+	}
+
+	method := req.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	var httpReq *http.Request
+	var signed []byte
+	var err error
+	if method == http.MethodGet {
+		var query map[string]string
+		if query, err = c.query(t, req.Param); err != nil {
+			return nil, nil, err
+		}
+		//服务端把url参数转成json后校验签名
+		if signed, err = json.Marshal(query); err != nil {
+			return nil, nil, err
+		}
+		values := url.Values{}
+		for k, v := range query {
+			values.Set(k, v)
+		}
+		httpReq, err = http.NewRequestWithContext(ctx, method, c.BaseURL+req.Path+"?"+values.Encode(), nil)
+	} else if len(req.Files) > 0 {
+		if req.Encrypt {
+			return nil, nil, fmt.Errorf("upload does not support encrypt")
+		}
+		if signed, err = c.body(t, ak, req); err != nil {
+			return nil, nil, err
+		}
+		body, contentType := multipartBody(signed, req.Files)
+		httpReq, err = http.NewRequestWithContext(ctx, method, c.BaseURL+req.Path, body)
+		if err == nil {
+			httpReq.Header.Set("Content-Type", contentType)
+		}
+	} else {
+		if signed, err = c.body(t, ak, req); err != nil {
+			return nil, nil, err
+		}
+		httpReq, err = http.NewRequestWithContext(ctx, method, c.BaseURL+req.Path, bytes.NewReader(signed))
+		if err == nil {
+			httpReq.Header.Set("Content-Type", "application/json; charset=utf-8")
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if ak != nil {
+		httpReq.Header.Set(contentSign, cipher.Sign(signed, ak))
+	}
+	if c.UserAgent != "" {
+		httpReq.Header.Set("User-Agent", c.UserAgent)
+	}
+	//请求id
+	if id := trace.Id(ctx); id != "" {
+		httpReq.Header.Set(trace.Header, id)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 20 * time.Second}
+		if stream {
+			httpClient = &http.Client{}
+		}
+	}
+	httpResp, err := httpClient.Do(httpReq)
+	return httpResp, ak, err
+}
+
+// body 生成请求的body，业务参数与 t、d、v 合并，加密时业务参数放在 e 中
+func (c *Client) body(t string, ak []byte, req Request) ([]byte, error) {
+	m := make(map[string]interface{})
+	if req.Param != nil {
+		b, err := json.Marshal(req.Param)
+		if err != nil {
+			return nil, err
+		}
+		if req.Encrypt {
+			crypt, err := cipher.AesEncrypt(b, cipher.AesKey(ak))
+			if err != nil {
+				return nil, err
+			}
+			m["e"] = cipher.Base64EncryptBytes(crypt)
+		} else if err = json.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("param must be an object: %s", err)
+		}
+	}
+	if len(req.Files) > 0 {
+		//文件摘要在签名的参数中
+		digests := make(map[string]string, len(req.Files))
+		for _, f := range req.Files {
+			digest, err := fileDigest(f.Body)
+			if err != nil {
+				return nil, err
+			}
+			digests[f.Field] = digest
+		}
+		m["files"] = digests
+	}
+	m["t"] = t
+	m["d"] = c.DeviceId
+	m["v"] = c.Version
+	m["ts"] = time.Now().Unix()
+	m["n"] = nonce()
+	return json.Marshal(m)
+}
+
+// fileDigest 文件内容的sha256，读完后回到开头
+func fileDigest(r io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// multipartBody 流式生成上传的body，第一个字段为 data
+func multipartBody(data []byte, files []File) (io.Reader, string) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		err := func() error {
+			if err := writer.WriteField("data", string(data)); err != nil {
+				return err
+			}
+			for _, f := range files {
+				part, err := writer.CreateFormFile(f.Field, f.Name)
+				if err != nil {
+					return err
+				}
+				if _, err = io.Copy(part, f.Body); err != nil {
+					return err
+				}
+			}
+			return writer.Close()
+		}()
+		_ = pw.CloseWithError(err)
+	}()
+	return pr, writer.FormDataContentType()
+}
+
+// query 生成url参数
+func (c *Client) query(t string, param interface{}) (map[string]string, error) {
+	query := make(map[string]string)
+	if param != nil {
+		b, err := json.Marshal(param)
+		if err != nil {
+			return nil, err
+		}
+		var m map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.UseNumber()
+		if err = decoder.Decode(&m); err != nil {
+			return nil, fmt.Errorf("param must be an object: %s", err)
+		}
+		for k, v := range m {
+			if s, ok := v.(string); ok {
+				query[k] = s
+			} else {
+				query[k] = fmt.Sprint(v)
+			}
+		}
+	}
+	query["t"] = t
+	query["d"] = c.DeviceId
+	query["v"] = fmt.Sprint(c.Version)
+	query["ts"] = fmt.Sprint(time.Now().Unix())
+	query["n"] = nonce()
+	return query, nil
+}
+
+// nonce 防重放的随机字符串
+func nonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"errors"
+	basic "github.com/qiaojun2016/basic/http"
+	"github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/id"
+	"github.com/qiaojun2016/basic/token"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type (
+	user struct {
+		Name string `json:"name"`
+	}
+
+	userResult struct {
+		Name    string `json:"name"`
+		Device  string `json:"device"`
+		Id      int64  `json:"id"`
+		Version int64  `json:"version"`
+	}
+)
+
+// newHandler 注册测试的路由，使用与 Run 相同的中间件
+func newHandler(t *testing.T) http.Handler {
+	id.Server{Node: 1}.Run()
+	token.Server{Secret: []byte("client-test-secret-0123456789")}.Run()
+	snapshot := route.Snapshot()
+	t.Cleanup(func() {
+		route.Restore(snapshot)
+	})
+	route.RegisterTyped(route.Route{Method: http.MethodPost, Url: "/test/client/login", Pattern: route.Pattern{Auth: route.AuthDisable}},
+		func(c *route.Context, req user) (map[string]string, error) {
+			tk := token.Token{Id: 7}
+			return map[string]string{"token": tk.Encode()}, nil
+		})
+	handle := func(c *route.Context, req user) (userResult, error) {
+		return userResult{Name: req.Name, Device: c.DeviceId, Id: c.Id, Version: c.Version}, nil
+	}
+	route.RegisterTyped(route.Route{Method: http.MethodPost, Url: "/test/client/user"}, handle)
+	route.RegisterTyped(route.Route{Method: http.MethodGet, Url: "/test/client/user"}, handle)
+	route.RegisterTyped(route.Route{Method: http.MethodPost, Url: "/test/client/secret", Pattern: route.Pattern{Encrypt: route.Enable}}, handle)
+	route.RegisterTyped(route.Route{Method: http.MethodPost, Url: "/test/client/public", Pattern: route.Pattern{Auth: route.AuthDisable}}, handle)
+
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+	})
+	return basic.Server{}.Handler(done)
+}
+
+func TestClient_Post(t *testing.T) {
+	srv := httptest.NewServer(newHandler(t))
+	defer srv.Close()
+	c := New(srv.URL)
+	c.DeviceId = "device"
+	c.Version = 3
+	ctx := context.Background()
+	if err := c.Login(ctx, "/test/client/login", nil, "token"); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []Request{
+		{Path: "/test/client/user", Param: user{Name: "basic"}},
+		{Method: http.MethodGet, Path: "/test/client/user", Param: user{Name: "basic"}},
+		{Path: "/test/client/secret", Param: user{Name: "basic"}, Encrypt: true},
+		{Path: "/test/client/public", Param: user{Name: "basic"}, NoAuth: true},
+	} {
+		res, err := Call[userResult](ctx, c, req)
+		if err != nil {
+			t.Fatal(req.Method, req.Path, err)
+		}
+		if res.Name != "basic" || res.Device != "device" || res.Version != 3 {
+			t.Fatalf("%s %+v", req.Path, res)
+		}
+		if !req.NoAuth && res.Id != 7 {
+			t.Fatalf("%s %+v", req.Path, res)
+		}
+	}
+	//开启认证的路由没有返回签名时必须报错
+	if _, err := c.Raw(ctx, Request{Path: "/test/client/public", Param: user{}}); err == nil || !strings.Contains(err.Error(), "signature missing") {
+		t.Fatal(err)
+	}
+	//服务端的中断返回错误码
+	_, err := New(srv.URL).Do(ctx, Request{Path: "/test/client/user", Param: user{}})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != route.CodeTokenMissing {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_signature(t *testing.T) {
+	handler := newHandler(t)
+	//去掉或修改返回数据的签名，模拟中间人
+	var tamper func(w http.ResponseWriter)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.Header().Del(contentSign)
+		tamper(w)
+		w.WriteHeader(rec.Code)
+		_, _ = w.Write(rec.Body.Bytes())
+	}))
+	defer proxy.Close()
+
+	c := New(proxy.URL)
+	ctx := context.Background()
+	tamper = func(w http.ResponseWriter) {}
+	if err := c.Login(ctx, "/test/client/login", nil, "token"); err != nil {
+		t.Fatal(err)
+	}
+	req := Request{Path: "/test/client/user", Param: user{Name: "basic"}}
+	if _, err := c.Raw(ctx, req); err == nil || !strings.Contains(err.Error(), "signature missing") {
+		t.Fatal(err)
+	}
+	tamper = func(w http.ResponseWriter) {
+		w.Header().Set(contentSign, "0123456789abcdef0123456789abcdef")
+	}
+	if _, err := c.Raw(ctx, req); err == nil || !strings.Contains(err.Error(), "signature mismatch") {
+		t.Fatal(err)
+	}
+}
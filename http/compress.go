@@ -0,0 +1,126 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	. "github.com/qiaojun2016/basic/http/route"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//响应压缩，在签名之后执行，Content-Sign 始终是未压缩数据的签名
+/*
+Accept-Encoding: br;q=1.0, gzip;q=0.8, *;q=0.1
+按 q 值选择 br 或 gzip，q 相同时优先 br，q=0 为不接受
+超过 Server.CompressMinBytes 的响应才压缩，Pattern.Compress 为 CompressDisable 的路由不压缩
+*/
+
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+var (
+	gzipPool = sync.Pool{New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}}
+	brotliPool = sync.Pool{New: func() interface{} {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}}
+)
+
+// acceptEncoding 根据 Accept-Encoding 选择压缩方式，不压缩时返回空
+func acceptEncoding(header string) string {
+	qs := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		qs[name] = q
+	}
+	quality := func(name string) float64 {
+		if q, ok := qs[name]; ok {
+			return q
+		}
+		return qs["*"]
+	}
+	br, gz := quality(encodingBrotli), quality(encodingGzip)
+	if br > 0 && br >= gz {
+		return encodingBrotli
+	}
+	if gz > 0 {
+		return encodingGzip
+	}
+	return ""
+}
+
+// compressible 已经压缩过的格式不再压缩
+func compressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range []string{"image/", "video/", "audio/", "application/zip", "application/gzip", "application/x-gzip"} {
+		if strings.HasPrefix(contentType, prefix) && contentType != "image/svg+xml" {
+			return false
+		}
+	}
+	return true
+}
+
+// compress 按请求协商压缩body，返回写出的数据
+func compress(c *Context, body []byte, minBytes int) []byte {
+	if minBytes <= 0 || len(body) < minBytes || c.Route.Pattern.Compress != Enable || !compressible(c.Route.ContentType) {
+		return body
+	}
+	header := c.Writer.Header()
+	header.Add("Vary", "Accept-Encoding")
+	if header.Get("Content-Encoding") != "" {
+		return body
+	}
+	encoding := acceptEncoding(c.Request.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return body
+	}
+
+	//压缩之后无法再根据内容判断类型
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType(body))
+	}
+	var buf bytes.Buffer
+	var err error
+	switch encoding {
+	case encodingBrotli:
+		bw := brotliPool.Get().(*brotli.Writer)
+		bw.Reset(&buf)
+		if _, err = bw.Write(body); err == nil {
+			err = bw.Close()
+		}
+		brotliPool.Put(bw)
+	default:
+		gw := gzipPool.Get().(*gzip.Writer)
+		gw.Reset(&buf)
+		if _, err = gw.Write(body); err == nil {
+			err = gw.Close()
+		}
+		gzipPool.Put(gw)
+	}
+	if err != nil {
+		//压缩失败时发送原始数据
+		return body
+	}
+	header.Set("Content-Encoding", encoding)
+	header.Del("Content-Length")
+	return buf.Bytes()
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	. "github.com/qiaojun2016/basic/http/route"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_acceptEncoding(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                       "",
+		"gzip":                   "gzip",
+		"gzip, deflate, br":      "br",
+		"br;q=0.5, gzip;q=0.8":   "gzip",
+		"br;q=0, gzip;q=0":       "",
+		"*":                      "br",
+		"identity, *;q=0":        "",
+		"GZIP;q=1.0, br;q=0.999": "gzip",
+	} {
+		if got := acceptEncoding(header); got != want {
+			t.Errorf("%q: got %q, want %q", header, got, want)
+		}
+	}
+}
+
+func Test_compress(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"state":"OK"}`), 200)
+	newContext := func(accept string, p PatternType) *Context {
+		r := httptest.NewRequest(http.MethodGet, "/list", nil)
+		r.Header.Set("Accept-Encoding", accept)
+		return &Context{
+			Writer:  httptest.NewRecorder(),
+			Request: r,
+			Route:   Route{Pattern: Pattern{Compress: p}},
+		}
+	}
+
+	c := newContext("gzip", Enable)
+	out := compress(c, body, 1024)
+	if c.Writer.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal(c.Writer.Header())
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, body) {
+		t.Fatal("gzip round trip mismatch")
+	}
+
+	//小于阈值、关闭压缩、客户端不支持
+	for _, c := range []*Context{newContext("gzip", Enable), newContext("gzip", CompressDisable), newContext("", Enable)} {
+		min := 1024
+		if c.Route.Pattern.Compress == Enable && c.Request.Header.Get("Accept-Encoding") != "" {
+			min = len(body) + 1
+		}
+		if out := compress(c, body, min); !bytes.Equal(out, body) || c.Writer.Header().Get("Content-Encoding") != "" {
+			t.Fatal(c.Writer.Header())
+		}
+	}
+}
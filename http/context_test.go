@@ -0,0 +1,57 @@
+package http
+
+import (
+	"context"
+	. "github.com/qiaojun2016/basic/http/route"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContext_deadline(t *testing.T) {
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	var deadline time.Time
+	var ok bool
+	RegisterTyped(Route{Method: http.MethodPost, Url: "/test/deadline", Pattern: Pattern{Auth: AuthDisable}},
+		func(c *Context, req map[string]interface{}) (bool, error) {
+			deadline, ok = c.Context().Deadline()
+			if req["wait"] == true {
+				<-c.Context().Done()
+			}
+			return true, nil
+		})
+	done := make(chan struct{})
+	defer close(done)
+	serve := func(h http.Handler, body string) *httptest.ResponseRecorder {
+		deadline, ok = time.Time{}, false
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/test/deadline", strings.NewReader(body)))
+		return w
+	}
+
+	//默认不限制
+	if w := serve(Server{}.Handler(done), "{}"); w.Code != http.StatusOK || ok {
+		t.Fatal(w.Code, deadline)
+	}
+	//配置了 WriteTimeout 时 handle 的 context 带截止时间，超时返回504
+	h := Server{WriteTimeout: 1}.Handler(done)
+	start := time.Now()
+	if w := serve(h, "{}"); w.Code != http.StatusOK || !ok || deadline.Before(start) || deadline.After(start.Add(time.Second+100*time.Millisecond)) {
+		t.Fatal(w.Code, ok, deadline.Sub(start))
+	}
+	w := serve(h, `{"wait":true}`)
+	if w.Code != http.StatusGatewayTimeout || !strings.Contains(w.Body.String(), `"code":50400`) {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	//客户端的 context 取消时同样取消
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = httptest.NewRecorder()
+	Server{}.Handler(done).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/test/deadline", strings.NewReader(`{"wait":true}`)).WithContext(ctx))
+	if w.Code != statusClientClosed {
+		t.Fatal(w.Code)
+	}
+}
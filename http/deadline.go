@@ -0,0 +1,16 @@
+//go:build go1.20
+
+package http
+
+import (
+	"net/http"
+	"time"
+)
+
+// streamDeadline 是否可以单独设置一个请求的写超时，流式输出的请求覆盖 http.Server.WriteTimeout
+const streamDeadline = true
+
+// setWriteDeadline 设置请求的写超时，deadline 为零值时不超时
+func setWriteDeadline(w http.ResponseWriter, deadline time.Time) error {
+	return http.NewResponseController(w).SetWriteDeadline(deadline)
+}
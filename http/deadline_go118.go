@@ -0,0 +1,16 @@
+//go:build !go1.20
+
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// streamDeadline go1.20 之前不能单独设置一个请求的写超时
+const streamDeadline = false
+
+func setWriteDeadline(w http.ResponseWriter, deadline time.Time) error {
+	return errors.New("set write deadline requires go1.20")
+}
@@ -0,0 +1,156 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/qiaojun2016/basic/health"
+	. "github.com/qiaojun2016/basic/http/route"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+//内置接口，与路由表中的路由冲突时不提供
+/*
+GET /healthz        进程存活
+GET /readyz         所有组件的检查，见 health.Readyz
+GET /debug/routes   路由表，Server.DebugToken 不为空时提供，请求头 Authorization: Bearer DebugToken
+*/
+
+const (
+	healthzPath     = "/healthz"
+	readyzPath      = "/readyz"
+	debugRoutesPath = "/debug/routes"
+)
+
+// routeInfo /debug/routes 中的一个路由
+type routeInfo struct {
+	Key         string     `json:"key"`
+	Method      string     `json:"method,omitempty"`
+	Url         string     `json:"url"`
+	Auth        bool       `json:"auth"`
+	Cache       bool       `json:"cache"`
+	CacheExpire int64      `json:"cacheExpire,omitempty"`
+	CacheTags   []string   `json:"cacheTags,omitempty"`
+	Encrypt     bool       `json:"encrypt"`
+	UserAgent   bool       `json:"userAgent"`
+	General     bool       `json:"general"`
+	Replay      bool       `json:"replay"`
+	Compress    bool       `json:"compress"`
+	Rate        float64    `json:"rate,omitempty"`
+	Burst       int        `json:"burst,omitempty"`
+	UserRate    float64    `json:"userRate,omitempty"`
+	UserBurst   int        `json:"userBurst,omitempty"`
+	Roles       []string   `json:"roles,omitempty"`
+	Permissions []string   `json:"permissions,omitempty"`
+	Version     int64      `json:"version"`
+	MaxVersion  int64      `json:"maxVersion,omitempty"`
+	Deprecation *time.Time `json:"deprecation,omitempty"`
+	Sunset      *time.Time `json:"sunset,omitempty"`
+	AllowIps    []string   `json:"allowIps,omitempty"`
+	DenyIps     []string   `json:"denyIps,omitempty"`
+	Upload      *Upload    `json:"upload,omitempty"`
+	Stream      string     `json:"stream,omitempty"`
+	Request     string     `json:"request,omitempty"`  //RegisterTyped 的请求参数类型
+	Response    string     `json:"response,omitempty"` //RegisterTyped 的返回数据类型
+	Middlewares int        `json:"middlewares"`        //路由中间件的个数
+}
+
+// newRouteInfo 路由的描述
+func newRouteInfo(r Route) routeInfo {
+	p := r.Pattern
+	info := routeInfo{
+		Key:         r.Key(),
+		Method:      r.Method,
+		Url:         r.Url,
+		Auth:        p.Auth == Enable,
+		Cache:       p.Cache == Enable,
+		CacheExpire: p.CacheExpire,
+		CacheTags:   p.CacheTags,
+		Encrypt:     p.Encrypt == Enable,
+		UserAgent:   p.UserAgent == Enable,
+		General:     p.General == Enable,
+		Replay:      p.Replay == Enable,
+		Compress:    p.Compress == Enable,
+		Rate:        p.Rate,
+		Burst:       p.Burst,
+		UserRate:    p.UserRate,
+		UserBurst:   p.UserBurst,
+		Roles:       p.Roles,
+		Permissions: p.Permissions,
+		Version:     p.Version,
+		MaxVersion:  p.MaxVersion,
+		AllowIps:    p.AllowIps,
+		DenyIps:     p.DenyIps,
+		Upload:      r.Upload,
+		Middlewares: len(r.Middlewares),
+	}
+	if !p.Deprecation.IsZero() {
+		info.Deprecation = &p.Deprecation
+	}
+	if !p.Sunset.IsZero() {
+		info.Sunset = &p.Sunset
+	}
+	if r.Stream != nil {
+		info.Stream = r.Stream.Format.ContentType()
+	}
+	if t := r.RequestType(); t != nil {
+		info.Request = t.String()
+	}
+	if t := r.ResponseType(); t != nil {
+		info.Response = t.String()
+	}
+	return info
+}
+
+// debugRoutes 输出路由表，token 错误时返回401
+func debugRoutes(token string) routeHandler {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		//必须带 Bearer 前缀，不接受单独的令牌
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authorization, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		infos := make([]routeInfo, 0, len(All()))
+		for _, route := range All() {
+			infos = append(infos, newRouteInfo(route))
+		}
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].Key < infos[j].Key
+		})
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(infos)
+	}
+}
+
+// handleBuiltin 注册内置接口，路由表中已有同样url的路由时跳过
+func (h Server) handleBuiltin(mux *router) {
+	urls := make(map[string]struct{})
+	for _, r := range All() {
+		urls[r.Url] = struct{}{}
+	}
+	builtin := map[string]routeHandler{
+		healthzPath: func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+			health.Healthz().ServeHTTP(w, r)
+		},
+		readyzPath: func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+			health.Readyz().ServeHTTP(w, r)
+		},
+	}
+	if h.DebugToken != "" {
+		builtin[debugRoutesPath] = debugRoutes(h.DebugToken)
+	}
+	for url, handler := range builtin {
+		if _, ok := urls[url]; ok {
+			continue
+		}
+		mux.handle(http.MethodGet, url, handler)
+	}
+}
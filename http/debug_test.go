@@ -0,0 +1,79 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/qiaojun2016/basic/health"
+	. "github.com/qiaojun2016/basic/http/route"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuiltin(t *testing.T) {
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	RegisterTyped(Route{
+		Method:  http.MethodPost,
+		Url:     "/test/debug",
+		Pattern: Pattern{Auth: AuthDisable, Version: 2},
+	}, func(c *Context, req map[string]interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	mux := Server{MaxPayloadBytes: 1 << 20, DebugToken: "secret"}.mux(nil)
+	getAuth := func(url, authorization string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		mux.ServeHTTP(w, r)
+		return w
+	}
+	get := func(url, token string) *httptest.ResponseRecorder {
+		if token == "" {
+			return getAuth(url, "")
+		}
+		return getAuth(url, "Bearer "+token)
+	}
+	if w := get("/healthz", ""); w.Code != http.StatusOK {
+		t.Fatal("healthz", w.Code)
+	}
+	health.Register("test", health.CheckerFunc(func(ctx context.Context) error {
+		return fmt.Errorf("down")
+	}))
+	if w := get("/readyz", ""); w.Code != http.StatusServiceUnavailable {
+		t.Fatal("readyz", w.Code)
+	}
+	health.Unregister("test")
+
+	if w := get("/debug/routes", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatal("debug token", w.Code)
+	}
+	//缺少 Bearer 前缀或前缀错误
+	for _, authorization := range []string{"secret", "bearer secret", "Basic secret", "Bearer", ""} {
+		if w := getAuth("/debug/routes", authorization); w.Code != http.StatusUnauthorized {
+			t.Fatal("debug scheme", authorization, w.Code)
+		}
+	}
+	w := get("/debug/routes", "secret")
+	var infos []routeInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	found := false
+	for _, info := range infos {
+		if info.Url == "/test/debug" {
+			found = info.Version == 2 && info.Request == "map[string]interface {}" && !info.Auth
+		}
+	}
+	if !found {
+		t.Fatal(w.Body.String())
+	}
+	//没有配置 DebugToken 时不提供
+	mux = Server{MaxPayloadBytes: 1 << 20}.mux(nil)
+	if w := get("/debug/routes", ""); w.Code != http.StatusNotFound {
+		t.Fatal("debug without token", w.Code)
+	}
+}
@@ -0,0 +1,126 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	stdcipher "crypto/cipher"
+	"encoding/json"
+	"github.com/qiaojun2016/basic/cipher"
+	"github.com/qiaojun2016/basic/http/client"
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/id"
+	"github.com/qiaojun2016/basic/token"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type encryptReq struct {
+	Phone string `json:"phone"`
+}
+
+func TestEncrypt(t *testing.T) {
+	id.Server{Node: 1}.Run()
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	handle := func(c *Context, req encryptReq) (string, error) {
+		return "phone " + req.Phone, nil
+	}
+	RegisterTyped(Route{Method: http.MethodPost, Url: "/test/encrypt", Pattern: Pattern{Encrypt: Enable}}, handle)
+	RegisterTyped(Route{Method: http.MethodPost, Url: "/test/encrypt/disable", Pattern: Pattern{Encrypt: EncryptDisable}}, handle)
+	tokens := &token.Manager{TTL: time.Hour, Store: token.NewLocalRevokeStore()}
+	srv := httptest.NewServer(Server{MaxPayloadBytes: 1 << 20, Tokens: tokens}.mux(nil))
+	defer srv.Close()
+	pair := tokens.Issue(9)
+	tk := token.Token{}
+	if err := tk.Decode(pair.Token); err != nil {
+		t.Fatal(err)
+	}
+	ak := []byte(tk.AccessKeyID())
+
+	//客户端加密参数、解密返回数据
+	c := client.New(srv.URL)
+	if err := c.SetToken(pair.Token); err != nil {
+		t.Fatal(err)
+	}
+	var result string
+	resp, err := c.Do(context.Background(), client.Request{Path: "/test/encrypt", Param: encryptReq{Phone: "15166077180"}, Encrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(resp.Data, &result); err != nil || result != "phone 15166077180" {
+		t.Fatal(err, result)
+	}
+
+	//返回的body是密文，解密后是 {version,state,data}
+	post := func(url string, body []byte) (int, []byte) {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+url, bytes.NewReader(body))
+		req.Header.Set("Content-Sign", cipher.Sign(body, ak))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, b
+	}
+	encrypted := func(crypt []byte) []byte {
+		b, _ := json.Marshal(map[string]string{"t": pair.Token, "e": cipher.Base64EncryptBytes(crypt)})
+		return b
+	}
+	crypt, err := cipher.AesEncrypt([]byte(`{"phone":"1"}`), cipher.AesKey(ak))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, b := post("/test/encrypt", encrypted(crypt))
+	if status != http.StatusOK || bytes.Contains(b, []byte("phone 1")) {
+		t.Fatal(status, string(b))
+	}
+	crypt, _ = cipher.Base64DecryptBytes(string(b))
+	plain, err := cipher.AesDecrypt(crypt, cipher.AesKey(ak))
+	if err != nil || !strings.Contains(string(plain), `"data":"phone 1"`) {
+		t.Fatal(err, string(plain))
+	}
+
+	//补码错误：16个0加密后，解密的最后一个字节为0
+	key := cipher.AesKey(ak)
+	block, _ := aes.NewCipher(key)
+	badPadding := make([]byte, aes.BlockSize)
+	stdcipher.NewCBCEncrypter(block, key).CryptBlocks(badPadding, make([]byte, aes.BlockSize))
+	for name, body := range map[string][]byte{
+		"padding": encrypted(badPadding),
+		"length":  encrypted([]byte("123")),
+		"base64":  []byte(`{"t":"` + pair.Token + `","e":"!!!"}`),
+		"json":    []byte(`{"t":"` + pair.Token + `","e":`),
+	} {
+		status, b = post("/test/encrypt", body)
+		if status != http.StatusBadRequest {
+			t.Fatal(name, status, string(b))
+		}
+	}
+	//修改了body但没有重新签名
+	body := encrypted(crypt)
+	sign := cipher.Sign(body, ak)
+	tampered := bytes.Replace(body, []byte(`"e":"`), []byte(`"e":"A`), 1)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/test/encrypt", bytes.NewReader(tampered))
+	req.Header.Set("Content-Sign", sign)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotAcceptable {
+		t.Fatal(res.StatusCode)
+	}
+
+	//EncryptDisable 的路由使用明文，忽略 e
+	b, _ = json.Marshal(map[string]string{"t": pair.Token, "phone": "2", "e": "!!!"})
+	status, b = post("/test/encrypt/disable", b)
+	if status != http.StatusOK || !strings.Contains(string(b), `"data":"phone 2"`) {
+		t.Fatal(status, string(b))
+	}
+}
@@ -0,0 +1,54 @@
+package http
+
+import (
+	"fmt"
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/ip"
+	"log"
+	"net/http"
+)
+
+//ip访问控制，在所有中间件之前执行
+/*
+1. 封禁中的ip返回 403 CodeIpBanned
+2. Server.DenyIps、Server.AllowIps，之后是路由的 Pattern.DenyIps、Pattern.AllowIps，不允许时返回 403 CodeIpDenied
+3. 请求被限流、令牌错误、签名错误时记录一次违规，次数达到 BanList.Threshold 时封禁
+*/
+
+// strikeCodes 记为违规的错误码。令牌错误不记：密钥配置错误或轮换时正常的客户端也会校验失败
+var strikeCodes = map[int]string{
+	CodeTooManyRequests: "rate limited",
+	CodeSignMismatch:    "signature mismatch",
+	CodeReplay:          "replay",
+}
+
+// newAccess 解析ip列表，配置错误时结束程序
+func newAccess(name string, allow, deny []string) *ip.Access {
+	access, err := ip.NewAccess(allow, deny)
+	if err != nil {
+		log.Panicf("'%s' %s", name, err)
+	}
+	return access
+}
+
+// Guard ip访问控制和自动封禁。server 为全局的列表，routes 为 Route.Key 对应的路由列表，bans 为空时不封禁
+func Guard(server *ip.Access, routes map[string]*ip.Access, bans *ip.BanList) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if bans.Banned(c.RealIp) {
+				c.AbortWithError(http.StatusForbidden, ErrIpBanned.WithMessage(fmt.Sprintf("%s : %s 已被临时封禁", c.Pattern, c.RealIp)))
+				return
+			}
+			if !server.Allowed(c.RealIp) || !routes[c.Pattern].Allowed(c.RealIp) {
+				c.AbortWithError(http.StatusForbidden, ErrIpDenied.WithMessage(fmt.Sprintf("%s : %s 禁止访问", c.Pattern, c.RealIp)))
+				return
+			}
+			next(c)
+			if err := c.AbortError(); err != nil {
+				if reason, ok := strikeCodes[err.Code]; ok {
+					bans.Strike(c.RealIp, reason)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package http
+
+import (
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/ip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGuard(t *testing.T) {
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	RegisterTyped(Route{
+		Method:  http.MethodPost,
+		Url:     "/test/guard",
+		Pattern: Pattern{Auth: AuthDisable, DenyIps: []string{"1.2.3.5"}, Rate: 1, Burst: 1},
+	}, func(c *Context, req map[string]interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	RegisterTyped(Route{Method: http.MethodPost, Url: "/test/guard/auth"}, func(c *Context, req map[string]interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	bans := &ip.BanList{Threshold: 2, Window: time.Minute, Store: ip.NewLocalBanStore()}
+	mux := Server{MaxPayloadBytes: 1 << 20, DenyIps: []string{"1.2.3.6"}, BanList: bans}.mux(nil)
+	postTo := func(url, body, remote string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		r.RemoteAddr = remote
+		mux.ServeHTTP(w, r)
+		return w.Code
+	}
+	post := func(remote string) int {
+		return postTo("/test/guard", `{}`, remote)
+	}
+	if post("1.2.3.5:1") != http.StatusForbidden || post("1.2.3.6:1") != http.StatusForbidden {
+		t.Fatal("deny list")
+	}
+	//路由限流每秒1次，两次被限流后封禁
+	codes := []int{post("1.2.3.4:1"), post("1.2.3.4:1"), post("1.2.3.4:1"), post("1.2.3.4:1")}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests || codes[3] != http.StatusForbidden || !bans.Banned("1.2.3.4") {
+		t.Fatal(codes)
+	}
+	//令牌错误不记为违规
+	for i := 0; i < 4; i++ {
+		if code := postTo("/test/guard/auth", `{"t":"invalid"}`, "1.2.3.7:1"); code != http.StatusNotAcceptable {
+			t.Fatal(code)
+		}
+	}
+	if bans.Banned("1.2.3.7") {
+		t.Fatal("banned for invalid token")
+	}
+}
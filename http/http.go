@@ -1,21 +1,21 @@
 package http
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"github.com/qiaojun2016/basic/cipher"
 	"github.com/qiaojun2016/basic/color"
+	"github.com/qiaojun2016/basic/health"
+	"github.com/qiaojun2016/basic/http/openapi"
 	. "github.com/qiaojun2016/basic/http/route"
-	"github.com/qiaojun2016/basic/id"
 	"github.com/qiaojun2016/basic/ip"
-	"github.com/qiaojun2016/basic/redis"
+	"github.com/qiaojun2016/basic/tlsServer"
 	"github.com/qiaojun2016/basic/token"
+	"github.com/qiaojun2016/basic/trace"
 	"golang.org/x/time/rate"
-	"io/ioutil"
+	"io"
 	"log"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 )
@@ -47,25 +47,60 @@ body: {
 }
 */
 
+//加密模式（Pattern.Encrypt 开启）
+/*
+收到数据，e 为业务参数json的AES密文，签名计算的是整个body
+body: {
+	"t":"token",
+	"d":"deviceId",
+	"v":1,
+	"e":"base64(aes(json))"
+}
+返回数据，body 为 {version,state,data} 的AES密文，签名计算的是body
+body: base64(aes(json))
+密钥为 cipher.AesKey(AccessKeyID)，可以由同一个请求中的令牌 t 计算，
+能看到请求的人都可以解密，加密只是混淆，机密性依赖TLS
+*/
+
 const (
 	contentSign     = "Content-Sign"   //指纹
 	maxRequestCount = 2000             //存活周期内的最大请求数 1200
-	dumpPeriod      = 10 * time.Minute //清理周期 10
+	dumpPeriod      = 10 * time.Minute //进程内限流器的清理周期 10
 	maxAliveTime    = 10 * time.Minute //存活周期 10
+
+	statusClientClosed = 499 //客户端在处理完成之前断开
 )
 
 type (
 	Server struct {
-		Addr            string      //监听地址
-		MaxPayloadBytes int         //最大消息长度
-		MaxHeaderBytes  int         //最大head息长度
-		Rate            rate.Limit  //每秒产生令牌的个数
-		Burst           int         //令牌桶大小个数
-		ReadTimeout     int         //读超时秒
-		WriteTimeout    int         //写超时秒
-		Web             bool        //是否是用于web，跨域
-		UserAgent       string      //允许的UserAgent
-		CorsCfg         *CORSConfig // cros配置，web 为 true  有效
+		Addr             string         //监听地址
+		MaxPayloadBytes  int            //最大消息长度
+		MaxHeaderBytes   int            //最大head息长度
+		Rate             rate.Limit     //每秒产生令牌的个数
+		Burst            int            //令牌桶大小个数
+		ReadTimeout      int            //读超时秒
+		WriteTimeout     int            //写超时秒，也是 c.Context() 的截止时间，为0时不限制，流式输出的请求使用 Stream.MaxDuration
+		Web              bool           //是否是用于web，跨域
+		UserAgent        string         //允许的UserAgent
+		CorsCfg          *CORSConfig    // cros配置，web 为 true  有效
+		Middlewares      []Middleware   //全局中间件，在内置中间件之后、路由中间件之前执行
+		OpenAPIPath      string         //OpenAPI文档地址，为空时不提供，以 .yaml 结尾时为yaml格式
+		OpenAPIInfo      openapi.Info   //OpenAPI文档信息
+		ReplayWindow     int            //防重放时间戳允许的误差秒，默认15
+		NonceStore       NonceStore     //防重放nonce的存储，为空时按 redis、badger 的顺序选择
+		Limiter          Limiter        //限流器，为空时使用进程内的 LocalLimiter，多个实例时使用 RedisLimiter
+		CompressMinBytes int            //响应超过该长度时按 Accept-Encoding 压缩，默认1024，小于0时不压缩
+		AccessLog        io.Writer      //访问日志，每个请求一行json，为空时为标准输出
+		AccessLogLevel   LogLevel       //访问日志级别，默认 LogOff 不输出
+		RoleResolver     RoleResolver   //角色和权限，有路由设置了 Pattern.Roles 或 Pattern.Permissions 时必须配置
+		TLS              *tlsServer.TLS //证书配置，为空时监听http
+		IpResolver       *ip.Resolver   //客户端ip，为空时使用 ip.DefaultResolver，只信任本机和内网代理的 X-Forwarded-For
+		AllowIps         []string       //允许的ip或网段，为空时不限制
+		DenyIps          []string       //拒绝的ip或网段，优先于 AllowIps
+		BanList          *ip.BanList    //自动封禁，为空时不封禁，管理接口见 ip.BanList.Handler
+		DebugToken       string         //不为空时提供 /debug/routes，请求头 Authorization: Bearer DebugToken
+		CacheStore       CacheStore     //缓存的存储，为空时使用redis，只用于这个服务，清除缓存见 Server.InvalidateRoute
+		Tokens           *token.Manager //令牌的有效期和吊销，为空时令牌不过期，有认证的路由时必须先运行 token.Server 设置密钥
 	}
 
 	CORSConfig struct {
@@ -73,506 +108,312 @@ type (
 	}
 
 	auth struct {
-		Token    string `json:"t"`
-		DeviceId string `json:"d"`
-		Version  int64  `json:"v"`
+		Token     string `json:"t"`
+		DeviceId  string `json:"d"`
+		Version   int64  `json:"v"`
+		Encrypted string `json:"e"`  //加密模式下的业务参数密文
+		Timestamp int64  `json:"ts"` //秒时间戳，防重放
+		Nonce     string `json:"n"`  //随机字符串，防重放
 	}
 
-	//response 返回数据
+	//response 返回数据，出错时 error 为带错误码的错误，见 route.Error
 	response struct {
 		Version int64       `json:"version"`
 		State   string      `json:"state"`
 		Data    interface{} `json:"data"`
+		Error   *Error      `json:"error,omitempty"`
 	}
 
-	iPItem struct {
-		count    int           //访问次数
-		lastDate time.Time     //最后的活跃时间
-		limiter  *rate.Limiter //限流器
-	}
-
-	//iPRateLimiter ip限流
-	iPRateLimiter struct {
-		ips   map[string]*iPItem
-		mu    *sync.RWMutex
-		rate  rate.Limit //每秒像桶中放入的令牌数量
-		burst int        //令牌桶大小
+	// Service 服务的句柄，Run 阻塞运行，Shutdown、Stop 可以在其他协程中调用
+	Service struct {
+		server     Server
+		mu         sync.Mutex
+		httpServer *http.Server     //Run 之后有效
+		done       chan struct{}    //关闭后台协程
+		closed     bool             //已调用 Shutdown 或 Stop
+		listener   *health.Listener //健康检查，关闭或监听失败后不再就绪
 	}
 )
 
-func (i *iPRateLimiter) ipLimiter(ip string) (ipItem *iPItem) {
-	i.mu.Lock()
-	ipItem, exists := i.ips[ip]
-	if !exists { //不存在
-		ipItem = &iPItem{
-			limiter: rate.NewLimiter(i.rate, i.burst),
-		}
-		i.ips[ip] = ipItem
+// encrypt 加密返回的数据
+func encrypt(data, ak []byte) ([]byte, error) {
+	crypt, err := cipher.AesEncrypt(data, cipher.AesKey(ak))
+	if err != nil {
+		return nil, err
 	}
-	ipItem.lastDate = time.Now()
-	ipItem.count++
-	i.mu.Unlock()
-	return ipItem
+	return []byte(cipher.Base64EncryptBytes(crypt)), nil
 }
 
-// dump 清除不活跃的ip，重置高频ip，释放内存
-func (i *iPRateLimiter) dump() {
-	ticker := time.NewTicker(dumpPeriod)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				now := time.Now()
-				//log.Println("触发清理")
-				i.mu.Lock()
-				for k, v := range i.ips {
-					//判断是否在存活周期内
-					if v.lastDate.Add(maxAliveTime).Before(now) { //不再周期内
-						//清除不活跃ip
-						delete(i.ips, k)
-					} else { //在周期内
-						//初始高频ip为0
-						v.count = 0
-					}
+// decrypt 解密收到的业务参数
+func decrypt(data string, ak []byte) ([]byte, error) {
+	crypt, err := cipher.Base64DecryptBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.AesDecrypt(crypt, cipher.AesKey(ak))
+}
 
-				}
-				i.mu.Unlock()
-			}
-		}
-	}()
+// NewService 创建服务的句柄，需要关闭服务时使用，一个句柄只能 Run 一次
+func NewService(h Server) *Service {
+	return &Service{
+		server:   h,
+		done:     make(chan struct{}),
+		listener: &health.Listener{},
+	}
 }
 
-func cache(userAuth *auth, pattern string, param, result []byte) {
-	//去掉param的d和t
-	if redis.Redis != nil {
-		//去掉auth
-		param = bytes.Replace(param, []byte(userAuth.Token), []byte{}, 1)
-		param = bytes.Replace(param, []byte(userAuth.DeviceId), []byte{}, 1)
-		if err := redis.Redis.HSet(pattern, string(param), result); err != nil {
-			log.Println(err)
-			return
-		}
-		//log.Println("cached")
-	} else {
-		log.Println("redis not run")
+// Shutdown 优雅关闭服务：停止接收新的链接，等待处理中的请求（包括缓存写入）完成，
+// 并停止后台的限流清理协程。ctx 超时后返回 ctx 的错误。在 Run 之前调用时 Run 直接返回 nil
+func (s *Service) Shutdown(ctx context.Context) error {
+	if srv := s.close(); srv != nil {
+		return srv.Shutdown(ctx)
 	}
+	return nil
 }
 
-func getCache(userAuth *auth, pattern string, param []byte) (result []byte, err error) {
-	if redis.Redis != nil {
-		//去掉auth
-		param = bytes.Replace(param, []byte(userAuth.Token), []byte{}, 1)
-		param = bytes.Replace(param, []byte(userAuth.DeviceId), []byte{}, 1)
-		result, err = redis.Redis.HGet(pattern, string(param))
-		if err != nil {
-			log.Println(err)
-			return
-		}
-		//log.Println("hit cache")
-	} else {
-		err = fmt.Errorf("redis not run")
-		log.Println(err)
+// Stop 立即关闭服务，不等待处理中的请求
+func (s *Service) Stop() error {
+	if srv := s.close(); srv != nil {
+		return srv.Close()
 	}
-	return
+	return nil
 }
 
-// Run 启动服务
-func (h Server) Run() {
-	//当不配置的时候，使用以下默认配置
-	if h.Addr == "" {
-		h.Addr = ":80"
+// close 标记关闭并停止后台协程，返回已创建的 http.Server
+func (s *Service) close() *http.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.listener.Fail(fmt.Errorf("http server closed"))
+		close(s.done)
 	}
-	if h.MaxPayloadBytes == 0 {
-		h.MaxPayloadBytes = 1 << 20
+	return s.httpServer
+}
+
+// mux 组合中间件，生成路由
+func (h Server) mux(done <-chan struct{}) *router {
+	//全局中间件
+	var middlewares []Middleware
+	serverAccess := newAccess("Server", h.AllowIps, h.DenyIps)
+	routeAccess := make(map[string]*ip.Access)
+	for key, r := range All() {
+		if access := newAccess(key, r.Pattern.AllowIps, r.Pattern.DenyIps); access != nil {
+			routeAccess[key] = access
+		}
 	}
-	if h.MaxHeaderBytes == 0 {
-		h.MaxHeaderBytes = 1 << 20
+	if serverAccess != nil || len(routeAccess) > 0 || h.BanList != nil {
+		middlewares = append(middlewares, Guard(serverAccess, routeAccess, h.BanList))
 	}
-	if h.Rate == 0 {
-		h.Rate = 10
+	limiter := h.Limiter
+	if limiter == nil {
+		limiter = NewLocalLimiter(done)
 	}
-	if h.Burst == 0 {
-		h.Burst = 15
+	if h.Rate > 0 && h.Burst > 0 {
+		middlewares = append(middlewares, RateLimit(limiter, h.Rate, h.Burst))
 	}
-	if h.ReadTimeout == 0 {
-		h.ReadTimeout = 5
+	if h.Web == true {
+		middlewares = append(middlewares, Cors(h.CorsCfg))
 	}
-	if h.WriteTimeout == 0 {
-		h.ReadTimeout = 5
+	middlewares = append(middlewares,
+		UserAgent(h.UserAgent),
+		Parse(h.MaxPayloadBytes),
+	)
+	//解析参数之后按版本选择路由，之后的中间件使用选中的路由
+	versioned := []Middleware{
+		Version(),
+		Auth(h.Tokens),
+		Signature(),
+		Authorize(h.RoleResolver),
+		RouteLimit(limiter),
+		Replay(time.Duration(h.ReplayWindow)*time.Second, h.NonceStore),
+		Decrypt(),
 	}
+	versioned = append(versioned, h.Middlewares...)
 
-	//限流器
-	iPLimiter := iPRateLimiter{
-		ips:   make(map[string]*iPItem),
-		mu:    &sync.RWMutex{},
-		rate:  h.Rate,
-		burst: h.Burst,
-	}
-	if h.Rate > 0 && h.Burst > 0 {
-		iPLimiter.dump()
+	if h.RoleResolver == nil {
+		for key, r := range All() {
+			if len(r.Pattern.Roles) > 0 || len(r.Pattern.Permissions) > 0 {
+				log.Panicf("'%s' roles require Server.RoleResolver", key)
+			}
+		}
 	}
 
-	mux := http.NewServeMux()
+	resolver := h.IpResolver
+	if resolver == nil {
+		resolver = ip.DefaultResolver
+	}
+	mux := newRouter(h.Web)
+	accessLog := newAccessLogger(h.AccessLog, h.AccessLogLevel)
 
-	//执行路由表
-	routeList := All()
-	for s, r := range routeList {
+	cacheStore := h.cacheStore()
+	//执行路由表，同一个url的多个版本注册为一个路由
+	for _, v := range Versions() {
 		//闭包保存路由
-		func(pattern string, route Route) {
-			mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		func(versions []Route) {
+			handlers := make([]HandlerFunc, len(versions))
+			for i, route := range versions {
+				chain := append(append([]Middleware{}, versioned...), route.Middlewares...)
+				chain = append(chain, Cache(cacheStore))
+				handlers[i] = Chain(handle, chain...)
+			}
+			handler := Chain(dispatch(versions, handlers), middlewares...)
+			//解析参数之前使用最新的版本
+			route := versions[len(versions)-1]
+			pattern := route.Key()
+			mux.handle(route.Method, route.Url, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
 				//关闭
 				defer func() {
 					_ = r.Body.Close()
 				}()
-
-				realIp := ip.XRealIp(r)
-
-				if h.Rate > 0 && h.Burst > 0 {
-					//阻止高频ip
-					ipItem := iPLimiter.ipLimiter(realIp)
-					if ipItem.count > maxRequestCount { //高频ip
-						errStr := fmt.Sprintf("%s判定为高频请求ip", realIp)
-						fmt.Println(errStr)
-						http.Error(w, errStr, http.StatusTooManyRequests)
-						return
-					}
-					//限流
-					/*err := ipItem.limiter.Wait(context.Background())
-					if err != nil {
-						log.Printf("%s : %s", pattern, err)
-						http.Error(w, err.Error(), http.StatusInternalServerError)
-						return
-					}*/
-					if !ipItem.limiter.Allow() {
-						//抛弃多余流量
-						errStr := fmt.Sprintf("%s请求过快", realIp)
-						log.Println(errStr)
-						http.Error(w, errStr, http.StatusTooManyRequests)
-						return
-					}
-				}
-				if h.Web == true {
-					//跨域
-					originSet := make(map[string]struct{}, len(h.CorsCfg.AllowedOrigins))
-					for _, o := range h.CorsCfg.AllowedOrigins {
-						originSet[o] = struct{}{}
-					}
-
-					origin := r.Header.Get("Origin")
-					if _, ok := originSet[origin]; ok {
-						w.Header().Set("Access-Control-Allow-Origin", origin)
-						w.Header().Set("Vary", "Origin")
-						w.Header().Set("access-control-expose-headers", "Content-Sign")
-						//w.Header().Set("Access-Control-Allow-Credentials", "true")
-					}
-					w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-					w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, Content-Sign")
-					w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-					w.Header().Set("Pragma", "no-cache")
-					w.Header().Set("Expires", "0")
-
-					if r.Method == http.MethodOptions {
-						w.WriteHeader(http.StatusOK)
-						return
-					}
-				}
-
-				//处理header
-				//header := r.Header
-				userAgent := r.Header.Get("User-Agent")
-				if h.UserAgent != "" && route.Pattern.UserAgent == Enable {
-					if userAgent != "dev tool" {
-						agent := false
-						if strings.HasSuffix(h.UserAgent, "-*") { //包含通配符
-							ua := h.UserAgent[0 : len(h.UserAgent)-2]
-							agent = !strings.HasPrefix(userAgent, ua)
-						} else {
-							agent = userAgent != h.UserAgent
+				start := time.Now()
+				sw := &statusWriter{ResponseWriter: w}
+
+				//请求id和截止时间
+				requestId := trace.FromHeader(r.Header.Get(trace.Header))
+				w.Header().Set(trace.Header, requestId)
+				ctx := trace.WithId(r.Context(), requestId)
+				//流式输出的路由使用 Stream.MaxDuration，同时覆盖连接的写超时，其他路由仍然使用 Server.WriteTimeout
+				timeout := time.Duration(h.WriteTimeout) * time.Second
+				if route.Stream != nil {
+					timeout = route.Stream.MaxDuration
+					if h.WriteTimeout > 0 {
+						deadline := time.Time{}
+						if timeout > 0 {
+							deadline = start.Add(timeout)
 						}
-
-						if agent {
-							errStr := fmt.Sprintf("%s : %s", pattern, "User-Agent 错误")
-							fmt.Println(errStr)
-							http.Error(w, errStr, http.StatusForbidden)
-							return
-						}
-
-					}
-
-				}
-
-				//检查签名
-				sig := r.Header.Get(contentSign)
-				if route.Pattern.Auth == Enable {
-					//有认证必须要校验签名
-					if sig == "" {
-						errStr := fmt.Sprintf("%s : %s", pattern, "缺少数据签名")
-						fmt.Println(errStr)
-						http.Error(w, errStr, http.StatusForbidden)
-						return
+						//不是 http.Server 的连接（如测试）时不支持，忽略
+						_ = setWriteDeadline(w, deadline)
 					}
 				}
-
-				//请求数据
-				var paramByte []byte
-				var err error
-				//根据方法不同处理参数
-				if r.Method == http.MethodGet { //TODO get没有测试
-					var m = make(map[string]string)
-					for key, value := range r.URL.Query() {
-						m[key] = value[0]
-					}
-					//TODO 论证这里会不会有错
-					paramByte, err = json.Marshal(m)
-					if err != nil {
-						errStr := fmt.Sprintf("%s : %s", pattern, "读取url参数错误")
-						fmt.Println(errStr)
-						http.Error(w, errStr, http.StatusInternalServerError)
-						return
-					}
-				} else {
-					//读body
-					r.Body = http.MaxBytesReader(w, r.Body, int64(h.MaxPayloadBytes))
-					paramByte, err = ioutil.ReadAll(r.Body)
-					if err != nil {
-						errStr := fmt.Sprintf("%s : %s", pattern, "读取body错误")
-						fmt.Println(errStr)
-						http.Error(w, errStr, http.StatusRequestEntityTooLarge)
-						return
-					}
+				if timeout > 0 {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithTimeout(ctx, timeout)
+					defer cancel()
 				}
 
-				if len(paramByte) == 0 {
-					errStr := fmt.Sprintf("%s : %s", pattern, "body为空")
-					fmt.Println(errStr)
-					http.Error(w, errStr, http.StatusNoContent)
-					return
+				c := &Context{
+					Writer:    sw,
+					Request:   r.WithContext(ctx),
+					RequestId: requestId,
+					Pattern:   pattern,
+					Route:     route,
+					Params:    params,
+					RealIp:    resolver.ClientIp(r),
+					UserAgent: r.Header.Get("User-Agent"),
+					Sign:      r.Header.Get(contentSign),
 				}
-
-				var tId int64
-				var tSession int64
-				var ak []byte
-
-				userAuth := &auth{}
-				//提取 token、deviceId、version
-				err = json.Unmarshal(paramByte, userAuth)
-				if err != nil {
-					errStr := fmt.Sprintf("%s : %s", pattern, err)
-					fmt.Println(errStr)
-					http.Error(w, errStr, http.StatusInternalServerError)
-					return
-				}
-
-				//判断版本
-				if userAuth.Version < route.Pattern.Version {
-					//客户端版本太低
-					errStr := fmt.Sprintf(
-						"client version is %d, server version is %d. version is too low.",
-						userAuth.Version, route.Pattern.Version,
-					)
-					fmt.Println(errStr)
-					http.Error(w, errStr, http.StatusGone)
-					return
-				}
-
-				//认证
-				if route.Pattern.Auth == Enable { //启用认证
-					if userAuth.Token == "" {
-						errStr := fmt.Sprintf("%s : %s", pattern, "缺少令牌")
-						fmt.Println(errStr)
-						http.Error(w, errStr, http.StatusNotAcceptable)
-						return
-					}
-
-					//提起令牌内容
-					tk := token.Token{}
-					err = tk.Decode(userAuth.Token)
-					if err != nil {
-						errStr := fmt.Sprintf("%s : %s", pattern, "令牌错误")
-						fmt.Println(errStr)
-						http.Error(w, errStr, http.StatusNotAcceptable)
-						return
-					}
-
-					tId = tk.Id
-					tSession = tk.Session()
-					ak = []byte(tk.AccessKeyID())
-
-					//校验签名
-					if !cipher.CheckSign(sig, paramByte, ak) {
-						errStr := fmt.Sprintf("%s : %s", pattern, "指纹检验失败")
-						fmt.Println(errStr)
-						http.Error(w, errStr, http.StatusNotAcceptable)
-						return
-					}
-
-				}
-
-				//var jsonErr error
-
-				// 查找缓存，缓存一定是正确的结果
-				if route.Pattern.Cache == Enable {
-					//var bytes []byte
-					result, cacheErr := getCache(userAuth, pattern, paramByte)
-					//没找到
-					if cacheErr != nil {
-						//缓存穿透
-						log.Println(fmt.Sprintf("%s : %s", pattern, "Cache Penetration"))
-						log.Println(cacheErr)
-					} else {
-						//找到了
-						if route.Pattern.General == Enable {
-							//通用不格式直接输出
-							//输出
-							_, err = w.Write(result)
-							if err != nil {
-								errStr := fmt.Sprintf("%s : %s", pattern, err)
-								fmt.Println(errStr)
-								return
-							}
-						} else {
-							//签名输出
-							if route.Pattern.Auth == Enable {
-								responseSig := cipher.Sign(result, ak)
-								//写入header
-								w.Header().Set(contentSign, responseSig)
-							}
-							w.WriteHeader(http.StatusOK)
-							_, err = w.Write(result)
-							if err != nil {
-								errStr := fmt.Sprintf("%s : %s", pattern, err)
-								log.Println(errStr)
-								return
-							}
-						}
-						return
+				defer func() {
+					if p := recover(); p != nil {
+						recovery(c, sw, p)
 					}
-				}
-
-				//执行
-				var result interface{}
-				//检查是否有特殊的handle
-				//携带ip和id的Handle
-				ipHandle := route.IpHandle()
-				//携带session的Handle
-				sessionHandle := route.SessionHandle()
-				//携带User-Agent的Handle
-				userAgentHandle := route.UserAgentHandle()
-
-				//Handle
-				if userAgentHandle != nil {
-					result, err = userAgentHandle(userAgent, id.SId.ToString(tId), paramByte)
-				} else if sessionHandle != nil {
-					result, err = sessionHandle(id.SId.ToString(tSession), paramByte)
-				} else if ipHandle != nil {
-					result, err = ipHandle(realIp, id.SId.ToString(tId), paramByte)
-				} else {
-					result, err = route.Handle()(id.SId.ToString(tId), paramByte)
-				}
-
-				// 通用不格式直接输出
-				if route.Pattern.General == Enable {
+					observe(c.Pattern, sw, start)
+					accessLog.log(c, sw, start)
+				}()
+				handler(c)
+				write(c, h.CompressMinBytes)
+			})
+		}(v)
+	}
 
-					//这里的错误是不格式化的错误
-					if err != nil {
-						errStr := fmt.Sprintf("%s : %s", pattern, err)
-						fmt.Println(errStr)
-						http.Error(w, errStr, http.StatusInternalServerError)
-						return
-					}
+	//健康检查和路由表
+	h.handleBuiltin(mux)
 
-					if result == nil {
-						w.WriteHeader(http.StatusOK)
-						return
-					}
+	//文档
+	if h.OpenAPIPath != "" {
+		doc := openapi.Handler(h.OpenAPIInfo)
+		mux.handle(http.MethodGet, h.OpenAPIPath, func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+			doc(w, r)
+		})
+	}
 
-					//判断是bytes
-					switch value := result.(type) {
-					case []byte:
-					default:
-						errStr := fmt.Sprintf("%v is not []byte or []uint8", value)
-						fmt.Println(errStr)
-						http.Error(w, errStr, http.StatusInternalServerError)
-						return
-					}
-					if route.ContentType != "" {
-						w.Header().Set("Content-Type", route.ContentType)
-					}
-					w.WriteHeader(http.StatusOK)
+	return mux
+}
 
-					//缓存
-					res := result.([]byte)
-					if route.Pattern.Cache == Enable {
-						cache(userAuth, pattern, paramByte, res)
-					}
-					//输出
-					_, err = w.Write(res)
+// defaults 当不配置的时候，使用以下默认配置
+func (h *Server) defaults() {
+	if h.Addr == "" {
+		h.Addr = ":80"
+	}
+	if h.MaxPayloadBytes == 0 {
+		h.MaxPayloadBytes = 1 << 20
+	}
+	if h.MaxHeaderBytes == 0 {
+		h.MaxHeaderBytes = 1 << 20
+	}
+	if h.Rate == 0 {
+		h.Rate = 10
+	}
+	if h.Burst == 0 {
+		h.Burst = 15
+	}
+	if h.CompressMinBytes == 0 {
+		h.CompressMinBytes = 1024
+	}
+	if h.ReadTimeout == 0 {
+		h.ReadTimeout = 5
+	}
+	//WriteTimeout 没有默认值，为0时不限制，与之前的行为相同
+}
 
-					if err != nil {
-						errStr := fmt.Sprintf("%s : %s", pattern, err)
-						fmt.Println(errStr)
-						return
-					}
-					return
-				}
+// checkSecret 有开启认证的路由或配置了 Tokens 时必须先设置令牌的密钥，见 token.Server
+func (h Server) checkSecret() error {
+	if token.HasSecret() {
+		return nil
+	}
+	if h.Tokens != nil {
+		return fmt.Errorf("[http] Server.Tokens: %w", token.ErrNoSecret)
+	}
+	for key, r := range All() {
+		if r.Pattern.Auth == Enable {
+			return fmt.Errorf("[http] '%s' auth: %w", key, token.ErrNoSecret)
+		}
+	}
+	return nil
+}
 
-				// 处理结果
-				var jsonBytes []byte
-
-				// 这里的错误是经过格式化的错误
-				if err != nil {
-					errStr := fmt.Sprintf("%s : %s", pattern, err)
-					fmt.Println(errStr)
-					jsonBytes, err = json.Marshal(response{
-						route.Pattern.Version,
-						err.Error(),
-						nil,
-					})
-				} else {
-					jsonBytes, err = json.Marshal(response{
-						route.Pattern.Version,
-						"OK",
-						result,
-					})
-
-					//缓存
-					if route.Pattern.Cache == Enable {
-						cache(userAuth, pattern, paramByte, jsonBytes)
-					}
-				}
+// Handler 按路由表生成 http.Handler，不监听端口，用于测试或挂到其他服务上。
+// 使用与 Run 相同的默认配置，done 关闭时停止后台的限流清理协程，配置错误时结束程序
+func (h Server) Handler(done <-chan struct{}) http.Handler {
+	h.defaults()
+	if err := h.checkSecret(); err != nil {
+		log.Panicln(err)
+	}
+	return h.mux(done)
+}
 
-				//json错误
-				if err != nil {
-					errStr := fmt.Sprintf("%s : %s", pattern, err)
-					fmt.Println(errStr)
-					http.Error(w, errStr, http.StatusInternalServerError)
-					return
-				}
+// Run 启动服务，阻塞直到服务关闭，不需要关闭服务时使用，否则使用 NewService
+func (h Server) Run() error {
+	return NewService(h).Run()
+}
 
-				//TODO 判断是否使用gzip
+// Run 启动服务，阻塞直到服务关闭。调用 Shutdown 或 Stop 关闭时返回 nil，配置或监听失败时返回错误
+func (s *Service) Run() error {
+	h := s.server
+	h.defaults()
+	if err := h.checkSecret(); err != nil {
+		s.close()
+		return err
+	}
 
-				//计算hmac
-				if route.Pattern.Auth == Enable {
-					responseSig := cipher.Sign(jsonBytes, ak)
-					//写入header
-					w.Header().Set(contentSign, responseSig)
-				}
-				w.WriteHeader(http.StatusOK)
-				//写出结果
-				_, err = w.Write(jsonBytes)
-				if err != nil {
-					errStr := fmt.Sprintf("%s : %s", pattern, err)
-					log.Println(errStr)
-					return
-				}
-			})
-		}(s, r)
+	//配置错误时 mux 会 panic，在持有锁之前创建，调用方 recover 之后 Shutdown、Stop 仍然可用
+	mux := h.mux(s.done)
+	routeList := All()
+	//写超时对所有路由有效，流式输出的请求单独覆盖，不支持覆盖时拒绝配置
+	if h.WriteTimeout > 0 && !streamDeadline {
+		for key, r := range routeList {
+			if r.Stream != nil {
+				s.close()
+				return fmt.Errorf("[http] '%s' stream route with Server.WriteTimeout requires go1.20, set WriteTimeout to 0", key)
+			}
+		}
 	}
 
 	ips, err := ip.BoundLocalIP()
 	if err != nil {
+		s.close()
 		log.Println(err)
-		return
+		return err
 	}
 	if len(ips) == 0 {
 		ips = []string{
@@ -580,9 +421,30 @@ func (h Server) Run() {
 		}
 	}
 
+	//只在发布 http.Server 时持有锁，Shutdown 等待发布完成
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.httpServer != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("[http] service is already running")
+	}
+	s.httpServer = &http.Server{
+		Addr:           h.Addr,
+		ReadTimeout:    time.Duration(h.ReadTimeout) * time.Second,
+		WriteTimeout:   time.Duration(h.WriteTimeout) * time.Second,
+		MaxHeaderBytes: h.MaxHeaderBytes,
+		Handler:        mux,
+	}
+	httpServer := s.httpServer
+	s.mu.Unlock()
+
 	color.Success(fmt.Sprintf(
-		"[http] %s listening http://%s%s ,routes total:%d,ip limit:%d/%gs",
+		"[http] %s listening %s://%s%s ,routes total:%d,ip limit:%d/%gs",
 		h.UserAgent,
+		h.TLS.Scheme(),
 		ips[0],
 		h.Addr,
 		len(routeList),
@@ -590,15 +452,14 @@ func (h Server) Run() {
 		h.Rate,
 	))
 	//启动服务
-	server := &http.Server{
-		Addr:           h.Addr,
-		ReadTimeout:    time.Duration(h.ReadTimeout) * time.Second,
-		WriteTimeout:   time.Duration(h.WriteTimeout) * time.Second,
-		MaxHeaderBytes: h.MaxHeaderBytes,
-		Handler:        mux,
-	}
-	err = server.ListenAndServe()
-	if err != nil {
-		log.Println("[http] Listen error!", err)
+	health.Register("http", s.listener)
+	err = tlsServer.ListenAndServe(httpServer, h.TLS)
+	if err == http.ErrServerClosed {
+		return nil
 	}
+	//监听失败
+	s.close()
+	s.listener.Fail(err)
+	log.Println("[http] Listen error!", err)
+	return err
 }
@@ -0,0 +1,245 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/redis"
+	"golang.org/x/time/rate"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//限流使用 GCRA 算法，与令牌桶等价：每个key只保存一个理论到达时间 tat
+/*
+emission  = 1s / rate      产生一个令牌的时间
+tolerance = emission*burst 令牌桶装满的时间
+newTat    = max(tat, now) + emission
+newTat - tolerance > now 时拒绝，否则保存 newTat
+redis 中的key为 basic:rate:{key}，值为微秒的 tat，过期时间为令牌桶恢复满的时间
+*/
+
+const ratePrefix = "basic:rate:"
+
+type (
+	// RateResult 一次限流判断的结果
+	RateResult struct {
+		Allowed    bool          //是否放行
+		Limit      int           //令牌桶大小
+		Remaining  int           //剩余令牌
+		RetryAfter time.Duration //被拒绝时距离下一个令牌的时间
+		Reset      time.Duration //令牌桶恢复满的时间
+	}
+
+	// Limiter 限流器，key 相同的请求共享一个令牌桶
+	Limiter interface {
+		// Allow 从key的令牌桶中取一个令牌，r 为每秒产生令牌的个数，burst 为令牌桶大小
+		Allow(key string, r rate.Limit, burst int) (RateResult, error)
+	}
+
+	// LocalLimiter 进程内的限流器，多个实例时限制会成倍放大
+	LocalLimiter struct {
+		mu      sync.Mutex
+		tats    map[string]time.Time
+		stopped chan struct{} //清理协程退出后关闭
+	}
+
+	// RedisLimiter 使用redis的限流器，多个实例共享限制
+	RedisLimiter struct{}
+)
+
+// NewLocalLimiter 创建进程内的限流器，后台清理协程在 done 关闭时退出，done 为 nil 时随进程存活
+func NewLocalLimiter(done <-chan struct{}) *LocalLimiter {
+	l := &LocalLimiter{
+		tats:    make(map[string]time.Time),
+		stopped: make(chan struct{}),
+	}
+	l.dump(done)
+	return l
+}
+
+func (l *LocalLimiter) Allow(key string, r rate.Limit, burst int) (RateResult, error) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tat, result := gcra(l.tats[key], now, r, burst)
+	l.tats[key] = tat
+	return result, nil
+}
+
+// dump 清除令牌桶已经恢复满的key，释放内存
+func (l *LocalLimiter) dump(done <-chan struct{}) {
+	ticker := time.NewTicker(dumpPeriod)
+	go func() {
+		defer close(l.stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				now := time.Now()
+				l.mu.Lock()
+				for k, tat := range l.tats {
+					if tat.Before(now) {
+						delete(l.tats, k)
+					}
+				}
+				l.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// gcra 根据上次的 tat 计算本次的结果，返回新的 tat
+func gcra(tat, now time.Time, r rate.Limit, burst int) (time.Time, RateResult) {
+	emission := emissionOf(r)
+	tolerance := emission * time.Duration(burst)
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(emission)
+	allowAt := newTat.Add(-tolerance)
+	result := RateResult{Limit: burst}
+	if allowAt.After(now) {
+		result.RetryAfter = allowAt.Sub(now)
+		result.Reset = tat.Sub(now)
+		return tat, result
+	}
+	result.Allowed = true
+	result.Remaining = int(now.Sub(allowAt) / emission)
+	result.Reset = newTat.Sub(now)
+	return newTat, result
+}
+
+// emissionOf 产生一个令牌的时间，至少1微秒
+func emissionOf(r rate.Limit) time.Duration {
+	emission := time.Duration(float64(time.Second) / float64(r))
+	if emission < time.Microsecond {
+		emission = time.Microsecond
+	}
+	return emission.Truncate(time.Microsecond)
+}
+
+// gcraScript 与 gcra 相同，时间单位为微秒
+var gcraScript = goredis.NewScript(`
+local emission = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if not tat or tat < now then
+	tat = now
+end
+local newTat = tat + emission
+local allowAt = newTat - emission * burst
+if allowAt > now then
+	return {0, 0, allowAt - now, tat - now}
+end
+redis.call('SET', KEYS[1], string.format('%.0f', newTat), 'PX', math.ceil((newTat - now) / 1000))
+return {1, math.floor((now - allowAt) / emission), 0, newTat - now}
+`)
+
+func (RedisLimiter) Allow(key string, r rate.Limit, burst int) (RateResult, error) {
+	if redis.Redis == nil {
+		return RateResult{}, fmt.Errorf("redis not run")
+	}
+	emission := emissionOf(r).Microseconds()
+	now := time.Now().UnixMicro()
+	values, err := gcraScript.Run(context.Background(), redis.Redis.Client(), []string{ratePrefix + key}, emission, burst, now).Int64Slice()
+	if err != nil {
+		return RateResult{}, err
+	}
+	if len(values) != 4 {
+		return RateResult{}, fmt.Errorf("rate limit script returned %d values", len(values))
+	}
+	return RateResult{
+		Allowed:    values[0] == 1,
+		Limit:      burst,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Microsecond,
+		Reset:      time.Duration(values[3]) * time.Microsecond,
+	}, nil
+}
+
+// seconds 向上取整的秒数
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// rateHeader 写入 RateLimit-* 头，多个限制同时生效时保留剩余最少的
+func rateHeader(w http.ResponseWriter, result RateResult) {
+	header := w.Header()
+	if remaining := header.Get("RateLimit-Remaining"); remaining != "" {
+		if n, err := strconv.Atoi(remaining); err == nil && n < result.Remaining && result.Allowed {
+			return
+		}
+	}
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", seconds(result.Reset))
+	if !result.Allowed {
+		header.Set("Retry-After", seconds(result.RetryAfter))
+	}
+}
+
+// allow 执行限流，限流器出错时放行，返回false时已经中断请求
+func allow(c *Context, limiter Limiter, key string, r rate.Limit, burst int, msg string) bool {
+	result, err := limiter.Allow(key, r, burst)
+	if err != nil {
+		log.Println(err)
+		return true
+	}
+	rateHeader(c.Writer, result)
+	if !result.Allowed {
+		rateLimitedTotal.Inc(c.Pattern)
+		c.AbortWithError(http.StatusTooManyRequests, ErrTooManyRequests.WithMessage(msg))
+		return false
+	}
+	return true
+}
+
+// RateLimit ip限流，r 为每秒产生令牌的个数，burst 为令牌桶大小，
+// 同时限制一个ip在 maxAliveTime 内最多 maxRequestCount 个请求
+func RateLimit(limiter Limiter, r rate.Limit, burst int) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			//阻止高频ip
+			if !allow(c, limiter, "count:"+c.RealIp, rate.Limit(float64(maxRequestCount)/maxAliveTime.Seconds()), maxRequestCount,
+				fmt.Sprintf("%s判定为高频请求ip", c.RealIp)) {
+				return
+			}
+			//抛弃多余流量
+			if !allow(c, limiter, "ip:"+c.RealIp, r, burst, fmt.Sprintf("%s请求过快", c.RealIp)) {
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+// RouteLimit 路由限流，在 Auth 之后执行。
+// Pattern.Rate 限制路由的总请求，Pattern.UserRate 按令牌中的id限制每个用户的请求
+func RouteLimit(limiter Limiter) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			p := c.Route.Pattern
+			if p.Rate > 0 {
+				if !allow(c, limiter, "route:"+c.Pattern, rate.Limit(p.Rate), p.Burst, fmt.Sprintf("%s请求过快", c.Pattern)) {
+					return
+				}
+			}
+			if p.UserRate > 0 && c.Id != 0 {
+				if !allow(c, limiter, fmt.Sprintf("user:%s:%d", c.Pattern, c.Id), rate.Limit(p.UserRate), p.UserBurst,
+					fmt.Sprintf("%s请求过快", c.Pattern)) {
+					return
+				}
+			}
+			next(c)
+		}
+	}
+}
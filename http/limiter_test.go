@@ -0,0 +1,43 @@
+package http
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_gcra(t *testing.T) {
+	now := time.Unix(1650000000, 0)
+	var tat time.Time
+	var result RateResult
+	//每秒1个，桶大小为2
+	for i, remaining := range []int{1, 0} {
+		tat, result = gcra(tat, now, 1, 2)
+		if !result.Allowed || result.Remaining != remaining {
+			t.Fatalf("request %d: %+v", i, result)
+		}
+	}
+	tat, result = gcra(tat, now, 1, 2)
+	if result.Allowed || result.RetryAfter != time.Second || result.Reset != 2*time.Second {
+		t.Fatalf("request 2: %+v", result)
+	}
+	//一秒后恢复一个令牌
+	_, result = gcra(tat, now.Add(time.Second), 1, 2)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("after 1s: %+v", result)
+	}
+}
+
+func Test_rateHeader(t *testing.T) {
+	w := httptest.NewRecorder()
+	rateHeader(w, RateResult{Allowed: true, Limit: 10, Remaining: 3, Reset: 1500 * time.Millisecond})
+	//剩余更多的限制不覆盖
+	rateHeader(w, RateResult{Allowed: true, Limit: 100, Remaining: 50, Reset: time.Second})
+	if w.Header().Get("RateLimit-Limit") != "10" || w.Header().Get("RateLimit-Remaining") != "3" || w.Header().Get("RateLimit-Reset") != "2" {
+		t.Fatal(w.Header())
+	}
+	rateHeader(w, RateResult{Limit: 100, RetryAfter: 200 * time.Millisecond, Reset: time.Second})
+	if w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("Retry-After") != "1" {
+		t.Fatal(w.Header())
+	}
+}
@@ -0,0 +1,58 @@
+package http
+
+import (
+	"github.com/qiaojun2016/basic/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//指标，route 标签为 Route.Key()，在 metrics.Server 的管理端口输出
+
+var (
+	requestsTotal     = metrics.NewCounter("basic_http_requests_total", "请求数", "route", "status")
+	requestDuration   = metrics.NewHistogram("basic_http_request_duration_seconds", "请求耗时", nil, "route")
+	rateLimitedTotal  = metrics.NewCounter("basic_http_rate_limited_total", "被限流拒绝的请求数", "route")
+	cacheTotal        = metrics.NewCounter("basic_http_cache_total", "缓存查询数，result 为 hit、miss、penetration", "route", "result")
+	signFailuresTotal = metrics.NewCounter("basic_http_signature_failures_total", "签名校验失败数", "route")
+)
+
+// statusWriter 记录写出的状态码
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int //写出的字节数
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Flush 支持流式输出
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// observe 记录一次请求
+func observe(pattern string, w *statusWriter, start time.Time) {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	requestsTotal.Inc(pattern, strconv.Itoa(status))
+	requestDuration.Observe(time.Since(start).Seconds(), pattern)
+}
@@ -0,0 +1,486 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/qiaojun2016/basic/cipher"
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/id"
+	"github.com/qiaojun2016/basic/token"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//内置中间件，Server.Run 按以下顺序组合，再接 Server.Middlewares、Route.Middlewares、Cache
+//Guard -> RateLimit -> Cors -> UserAgent -> Parse -> Version -> Auth -> Signature -> Authorize -> RouteLimit -> Replay -> Decrypt
+
+// Cors 跨域
+func Cors(cfg *CORSConfig) Middleware {
+	originSet := make(map[string]struct{})
+	if cfg != nil {
+		for _, o := range cfg.AllowedOrigins {
+			originSet[o] = struct{}{}
+		}
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			w := c.Writer
+			origin := c.Request.Header.Get("Origin")
+			if _, ok := originSet[origin]; ok {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Vary", "Origin")
+				w.Header().Set("access-control-expose-headers", "Content-Sign, Retry-After, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset")
+				//w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, Content-Sign")
+			w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+			w.Header().Set("Pragma", "no-cache")
+			w.Header().Set("Expires", "0")
+
+			if c.Request.Method == http.MethodOptions {
+				c.AbortWithStatus(http.StatusOK)
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+// UserAgent 校验User-Agent，以 -* 结尾时按前缀匹配，Pattern.UserAgent 开启的路由有效
+func UserAgent(userAgent string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if userAgent != "" && c.Route.Pattern.UserAgent == Enable && c.UserAgent != "dev tool" {
+				agent := false
+				if strings.HasSuffix(userAgent, "-*") { //包含通配符
+					ua := userAgent[0 : len(userAgent)-2]
+					agent = !strings.HasPrefix(c.UserAgent, ua)
+				} else {
+					agent = c.UserAgent != userAgent
+				}
+
+				if agent {
+					c.AbortWithError(http.StatusForbidden, ErrUserAgent.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "User-Agent 错误")))
+					return
+				}
+			}
+			next(c)
+		}
+	}
+}
+
+// Parse 读取请求参数，提取 token、deviceId、version
+func Parse(maxPayloadBytes int) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			r := c.Request
+			var err error
+			userAuth := &auth{}
+			//根据方法不同处理参数
+			if r.Method == http.MethodGet {
+				if c.Data, err = parseQuery(r, userAuth); err != nil {
+					c.Abort(http.StatusBadRequest, fmt.Sprintf("%s : %s", c.Pattern, err))
+					return
+				}
+			} else if c.Route.Upload != nil {
+				//上传，参数在 data 字段中，文件留给handle读取
+				if c.Data, err = parseUpload(c, maxPayloadBytes); err != nil {
+					return
+				}
+				if err = json.Unmarshal(c.Data, userAuth); err != nil {
+					c.Abort(http.StatusBadRequest, fmt.Sprintf("%s : %s", c.Pattern, err))
+					return
+				}
+			} else {
+				//读body
+				r.Body = http.MaxBytesReader(c.Writer, r.Body, int64(maxPayloadBytes))
+				c.Data, err = ioutil.ReadAll(r.Body)
+				if err != nil {
+					c.AbortWithError(http.StatusRequestEntityTooLarge, ErrTooLarge.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "读取body错误")))
+					return
+				}
+				if len(c.Data) == 0 {
+					c.Abort(http.StatusNoContent, fmt.Sprintf("%s : %s", c.Pattern, "body为空"))
+					return
+				}
+				//提取 token、deviceId、version，body 不是json时是请求的错误
+				if err = json.Unmarshal(c.Data, userAuth); err != nil {
+					c.Abort(http.StatusBadRequest, fmt.Sprintf("%s : %s", c.Pattern, err))
+					return
+				}
+			}
+
+			c.Token = userAuth.Token
+			c.DeviceId = userAuth.DeviceId
+			c.Version = userAuth.Version
+			c.Encrypted = userAuth.Encrypted
+			c.Timestamp = userAuth.Timestamp
+			c.Nonce = userAuth.Nonce
+			next(c)
+		}
+	}
+}
+
+// dispatch 按客户端版本选择路由，versions 按 Pattern.Version 排序，handlers 为对应的中间件链。
+// 没有匹配的版本时选择最接近的版本，由 Version 返回410
+func dispatch(versions []Route, handlers []HandlerFunc) HandlerFunc {
+	return func(c *Context) {
+		i := len(versions) - 1
+		for j, route := range versions {
+			if route.Covers(c.Version) || c.Version < route.Pattern.Version {
+				i = j
+				break
+			}
+		}
+		c.Route = versions[i]
+		c.Pattern = c.Route.Key()
+		handlers[i](c)
+	}
+}
+
+// Version 拒绝不在 Pattern.Version、Pattern.MaxVersion 范围内或已经停用的客户端，
+// 返回 Deprecation、Sunset 头
+func Version() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			p := c.Route.Pattern
+			if c.Version < p.Version {
+				//客户端版本太低
+				c.AbortWithError(http.StatusGone, ErrVersionGone.WithMessage(fmt.Sprintf(
+					"client version is %d, server version is %d. version is too low.",
+					c.Version, p.Version,
+				)))
+				return
+			}
+			if p.MaxVersion > 0 && c.Version > p.MaxVersion {
+				//没有支持该版本的路由
+				c.AbortWithError(http.StatusGone, ErrVersionGone.WithMessage(fmt.Sprintf(
+					"client version is %d, server max version is %d.",
+					c.Version, p.MaxVersion,
+				)))
+				return
+			}
+			h := c.Writer.Header()
+			if !p.Deprecation.IsZero() {
+				//RFC 9745
+				h.Set("Deprecation", fmt.Sprintf("@%d", p.Deprecation.Unix()))
+			}
+			if !p.Sunset.IsZero() {
+				//RFC 8594
+				h.Set("Sunset", p.Sunset.UTC().Format(http.TimeFormat))
+				if time.Now().After(p.Sunset) {
+					c.AbortWithError(http.StatusGone, ErrVersionGone.WithMessage(fmt.Sprintf(
+						"client version is %d, which is sunset at %s.",
+						c.Version, p.Sunset.Format(time.RFC3339),
+					)))
+					return
+				}
+			}
+			next(c)
+		}
+	}
+}
+
+// Auth 令牌认证，Pattern.Auth 开启的路由有效。tokens 为空时只解析令牌，不检查有效期和吊销
+func Auth(tokens *token.Manager) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if c.Route.Pattern.Auth == Enable { //启用认证
+				if c.Token == "" {
+					c.AbortWithError(http.StatusNotAcceptable, ErrTokenMissing.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "缺少令牌")))
+					return
+				}
+
+				//提起令牌内容，检查有效期和吊销
+				tk, err := tokens.Verify(c.Token)
+				switch err {
+				case nil:
+				case token.ErrInvalid:
+					c.AbortWithError(http.StatusNotAcceptable, ErrTokenInvalid.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "令牌错误")))
+					return
+				case token.ErrExpired:
+					c.AbortWithError(http.StatusUnauthorized, ErrTokenExpired.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "令牌已过期")))
+					return
+				case token.ErrRevoked:
+					c.AbortWithError(http.StatusUnauthorized, ErrTokenRevoked.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "令牌已吊销")))
+					return
+				default:
+					c.Abort(http.StatusInternalServerError, fmt.Sprintf("%s : %s", c.Pattern, err))
+					return
+				}
+
+				c.Id = tk.Id
+				c.Session = tk.Session()
+				c.AccessKey = []byte(tk.AccessKeyID())
+			}
+			next(c)
+		}
+	}
+}
+
+// Signature 校验签名，有认证必须要校验签名，在 Auth 之后执行
+func Signature() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if c.Route.Pattern.Auth == Enable {
+				if c.Sign == "" {
+					c.AbortWithError(http.StatusForbidden, ErrSignMissing.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "缺少数据签名")))
+					return
+				}
+				if !cipher.CheckSign(c.Sign, c.Data, c.AccessKey) {
+					signFailuresTotal.Inc(c.Pattern)
+					c.AbortWithError(http.StatusNotAcceptable, ErrSignMismatch.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "指纹检验失败")))
+					return
+				}
+			}
+			next(c)
+		}
+	}
+}
+
+// Decrypt 解密业务参数，签名校验的是密文，Pattern.Encrypt 开启的路由有效
+func Decrypt() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if c.Route.Pattern.Encrypt == Enable {
+				if c.Encrypted == "" {
+					c.AbortWithError(http.StatusNotAcceptable, ErrEncryptedMissing.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "缺少加密数据")))
+					return
+				}
+				data, err := decrypt(c.Encrypted, c.AccessKey)
+				if err != nil {
+					//密文格式或补码错误，是请求本身的错误
+					c.AbortWithError(http.StatusBadRequest, ErrDecryptFailed.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "解密失败")))
+					return
+				}
+				c.Data = data
+			}
+			next(c)
+		}
+	}
+}
+
+// Cache 查找缓存，命中时不再执行后续的中间件和handle，Pattern.Cache 开启的路由有效，store 为 Server.CacheStore
+func Cache(store CacheStore) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if c.Route.Pattern.Cache != Enable {
+				next(c)
+				return
+			}
+			// 缓存一定是正确的结果
+			if result, err := getCache(store, c); err == nil {
+				c.Body = result
+				c.CacheHit = true
+				return
+			}
+			next(c)
+			if c.Aborted() {
+				return
+			}
+			if c.Err != nil {
+				cachePenetrate(c.Pattern)
+				return
+			}
+			if c.Body != nil {
+				cache(store, c)
+			}
+		}
+	}
+}
+
+// handle 执行路由的handle，生成写出的数据
+func handle(c *Context) {
+	route := c.Route
+	//检查是否有特殊的handle
+	//携带ip和id的Handle
+	ipHandle := route.IpHandle()
+	//携带session的Handle
+	sessionHandle := route.SessionHandle()
+	//携带User-Agent的Handle
+	userAgentHandle := route.UserAgentHandle()
+	//携带上下文的Handle
+	contextHandle := route.ContextHandle()
+
+	//Handle
+	if contextHandle != nil {
+		c.Result, c.Err = contextHandle(c)
+	} else if userAgentHandle != nil {
+		c.Result, c.Err = userAgentHandle(c.UserAgent, id.SId.ToString(c.Id), c.Data)
+	} else if sessionHandle != nil {
+		c.Result, c.Err = sessionHandle(id.SId.ToString(c.Session), c.Data)
+	} else if ipHandle != nil {
+		c.Result, c.Err = ipHandle(c.RealIp, id.SId.ToString(c.Id), c.Data)
+	} else {
+		c.Result, c.Err = route.Handle()(id.SId.ToString(c.Id), c.Data)
+	}
+	//流式输出已经写出
+	if c.Streaming() {
+		return
+	}
+
+	//超时或客户端断开
+	switch c.Context().Err() {
+	case context.DeadlineExceeded:
+		c.AbortWithError(http.StatusGatewayTimeout, ErrTimeout.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "处理超时")))
+		return
+	case context.Canceled:
+		c.AbortWithStatus(statusClientClosed)
+		return
+	}
+
+	// 通用不格式直接输出
+	if route.Pattern.General == Enable {
+		//这里的错误是不格式化的错误
+		if c.Err != nil {
+			c.AbortWithError(http.StatusInternalServerError, AsError(c.Err))
+			return
+		}
+		if c.Result == nil {
+			return
+		}
+		//判断是bytes
+		value, ok := c.Result.([]byte)
+		if !ok {
+			c.Abort(http.StatusInternalServerError, fmt.Sprintf("%v is not []byte or []uint8", c.Result))
+			return
+		}
+		c.Body = value
+		return
+	}
+
+	// 这里的错误是经过格式化的错误
+	var err error
+	if c.Err != nil {
+		fmt.Println(fmt.Sprintf("%s : %s", c.Pattern, c.Err))
+		c.Body, err = json.Marshal(response{
+			Version: route.Pattern.Version,
+			State:   c.Err.Error(),
+			Error:   AsError(c.Err),
+		})
+	} else {
+		c.Body, err = json.Marshal(response{
+			Version: route.Pattern.Version,
+			State:   "OK",
+			Data:    c.Result,
+		})
+	}
+	//json错误
+	if err != nil {
+		c.Abort(http.StatusInternalServerError, fmt.Sprintf("%s : %s", c.Pattern, err))
+		return
+	}
+}
+
+// write 加密、签名并写出数据
+func write(c *Context, compressMinBytes int) {
+	if c.Aborted() || c.Streaming() {
+		return
+	}
+	w := c.Writer
+	route := c.Route
+	body := c.Body
+	if route.Pattern.General == Enable {
+		if body == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if route.ContentType != "" {
+			w.Header().Set("Content-Type", route.ContentType)
+		}
+	} else {
+		//加密
+		if route.Pattern.Encrypt == Enable {
+			var err error
+			if body, err = encrypt(body, c.AccessKey); err != nil {
+				c.Abort(http.StatusInternalServerError, fmt.Sprintf("%s : %s", c.Pattern, err))
+				return
+			}
+		}
+
+		//签名
+		if route.Pattern.Auth == Enable {
+			w.Header().Set(contentSign, cipher.Sign(body, c.AccessKey))
+		}
+	}
+	//压缩，签名为压缩之前的数据
+	body = compress(c, body, compressMinBytes)
+	w.WriteHeader(http.StatusOK)
+	//写出结果
+	if _, err := w.Write(body); err != nil {
+		fmt.Println(fmt.Sprintf("%s : %s", c.Pattern, err))
+	}
+}
+
+// parseQuery 读取GET请求的url参数，返回转换后的json，签名校验的是这个json。
+// url参数都是字符串，不能直接解析到 auth，单独提取 t、d、v、e、ts、n
+func parseQuery(r *http.Request, userAuth *auth) ([]byte, error) {
+	var m = make(map[string]string)
+	for key, value := range r.URL.Query() {
+		m[key] = value[0]
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	userAuth.Token = m["t"]
+	userAuth.DeviceId = m["d"]
+	userAuth.Encrypted = m["e"]
+	userAuth.Nonce = m["n"]
+	if v := m["v"]; v != "" {
+		if userAuth.Version, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	if ts := m["ts"]; ts != "" {
+		if userAuth.Timestamp, err = strconv.ParseInt(ts, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// parseUpload 读取上传请求的 data 字段，创建 c.Files，出错时已经中断请求
+func parseUpload(c *Context, maxPayloadBytes int) ([]byte, error) {
+	r := c.Request
+	r.Body = http.MaxBytesReader(c.Writer, r.Body, int64(maxPayloadBytes)+c.Route.Upload.MaxBytes())
+	reader, err := r.MultipartReader()
+	if err != nil {
+		c.Abort(http.StatusBadRequest, fmt.Sprintf("%s : %s", c.Pattern, err))
+		return nil, err
+	}
+	part, err := reader.NextPart()
+	if err != nil || part.FormName() != UploadField || part.FileName() != "" {
+		err = fmt.Errorf("第一个字段必须是 %s", UploadField)
+		c.Abort(http.StatusBadRequest, fmt.Sprintf("%s : %s", c.Pattern, err))
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(part, int64(maxPayloadBytes)+1))
+	if err != nil || len(data) > maxPayloadBytes {
+		c.AbortWithError(http.StatusRequestEntityTooLarge, ErrTooLarge.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "读取body错误")))
+		return nil, fmt.Errorf("data too large")
+	}
+	//开启认证时文件摘要在签名的 data 中
+	var digests map[string]string
+	if c.Route.Pattern.Auth == Enable {
+		var files struct {
+			Files map[string]string `json:"files"`
+		}
+		if err = json.Unmarshal(data, &files); err != nil {
+			c.Abort(http.StatusBadRequest, fmt.Sprintf("%s : %s", c.Pattern, err))
+			return nil, err
+		}
+		digests = files.Files
+		if digests == nil {
+			digests = map[string]string{}
+		}
+	}
+	c.Files = NewFiles(reader, *c.Route.Upload, digests)
+	return data, nil
+}
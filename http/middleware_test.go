@@ -0,0 +1,136 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/qiaojun2016/basic/http/client"
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/id"
+	"github.com/qiaojun2016/basic/token"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	var got *Context
+	handle := func(c *Context, req map[string]interface{}) (interface{}, error) {
+		got = c
+		return req["name"], nil
+	}
+	RegisterTyped(Route{Method: http.MethodGet, Url: "/test/parse", Pattern: Pattern{Auth: AuthDisable}}, handle)
+	RegisterTyped(Route{Method: http.MethodPost, Url: "/test/parse", Pattern: Pattern{Auth: AuthDisable}}, handle)
+	mux := Server{MaxPayloadBytes: 1 << 20}.mux(nil)
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		got = nil
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	//url参数都是字符串，v、ts 转为数字，其他参数交给handle
+	w := serve(httptest.NewRequest(http.MethodGet, "/test/parse?t=tk&d=dev&v=2&ts=100&n=nonce&name=basic", nil))
+	if w.Code != http.StatusOK || got == nil || !strings.Contains(w.Body.String(), `"data":"basic"`) {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if got.Token != "tk" || got.DeviceId != "dev" || got.Version != 2 || got.Timestamp != 100 || got.Nonce != "nonce" {
+		t.Fatalf("%+v", got)
+	}
+	if w = serve(httptest.NewRequest(http.MethodGet, "/test/parse?v=abc", nil)); w.Code != http.StatusBadRequest || got != nil {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w = serve(httptest.NewRequest(http.MethodGet, "/test/parse", nil)); w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	//body 为空或不是json
+	if w = serve(httptest.NewRequest(http.MethodPost, "/test/parse", nil)); w.Code != http.StatusNoContent || got != nil {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w = serve(httptest.NewRequest(http.MethodPost, "/test/parse", strings.NewReader("{"))); w.Code != http.StatusBadRequest || got != nil {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	w = serve(httptest.NewRequest(http.MethodPost, "/test/parse", strings.NewReader(`{"d":"dev","v":3,"name":"basic"}`)))
+	if w.Code != http.StatusOK || got == nil || got.DeviceId != "dev" || got.Version != 3 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	id.Server{Node: 1}.Run()
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	var order []string
+	record := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(c *Context) {
+				//认证之后执行，令牌已解析
+				order = append(order, name)
+				if c.Id != 7 {
+					t.Error(name, c.Id)
+				}
+				if c.Request.Header.Get("X-Abort") == name {
+					c.AbortWithError(http.StatusForbidden, ErrForbidden)
+					return
+				}
+				next(c)
+			}
+		}
+	}
+	RegisterTyped(Route{
+		Method:      http.MethodPost,
+		Url:         "/test/middleware",
+		Pattern:     Pattern{Cache: Enable},
+		Middlewares: []Middleware{record("route")},
+	}, func(c *Context, req map[string]interface{}) (bool, error) {
+		order = append(order, "handler")
+		return true, nil
+	})
+	srv := httptest.NewServer(Server{
+		MaxPayloadBytes: 1 << 20,
+		CacheStore:      NewMemoryCacheStore(),
+		Middlewares:     []Middleware{record("server1"), record("server2")},
+	}.mux(nil))
+	defer srv.Close()
+	tk := token.Token{Id: 7}
+	c := client.New(srv.URL)
+	if err := c.SetToken(tk.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	call := func(c *client.Client) error {
+		order = nil
+		_, err := c.Do(context.Background(), client.Request{Path: "/test/middleware"})
+		return err
+	}
+
+	//Server.Middlewares 按顺序在 Route.Middlewares 之前，缓存在两者之后
+	if err := call(c); err != nil || !reflect.DeepEqual(order, []string{"server1", "server2", "route", "handler"}) {
+		t.Fatal(err, order)
+	}
+	if err := call(c); err != nil || !reflect.DeepEqual(order, []string{"server1", "server2", "route"}) {
+		t.Fatal(err, order)
+	}
+	//认证失败时不执行
+	var statusErr *client.StatusError
+	if err := call(client.New(srv.URL)); !errors.As(err, &statusErr) || statusErr.Code != CodeTokenMissing || len(order) != 0 {
+		t.Fatal(err, order)
+	}
+	//中断后不再执行之后的中间件和handler
+	c.HTTPClient = &http.Client{Transport: roundTripper(func(r *http.Request) (*http.Response, error) {
+		r.Header.Set("X-Abort", "server1")
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	if err := call(c); !errors.As(err, &statusErr) || statusErr.Code != CodeForbidden || !reflect.DeepEqual(order, []string{"server1"}) {
+		t.Fatal(err, order)
+	}
+}
+
+type roundTripper func(r *http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
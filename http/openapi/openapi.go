@@ -0,0 +1,589 @@
+// Package openapi 根据路由表生成 OpenAPI 3 文档
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/qiaojun2016/basic/http/route"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const version = "3.0.3"
+
+type (
+	// Info 文档信息
+	Info struct {
+		Title       string
+		Version     string
+		Description string
+		Servers     []string //服务地址，如 https://api.example.com
+	}
+
+	// generator 生成文档时收集的结构体定义
+	generator struct {
+		schemas map[string]interface{}
+		names   map[reflect.Type]string
+	}
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Document 根据 route.All() 生成文档。
+// RegisterTyped 注册的路由使用请求、返回的结构体生成参数，其他方式注册的路由参数为任意对象
+func Document(info Info) map[string]interface{} {
+	g := &generator{
+		schemas: map[string]interface{}{
+			"Auth": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"t":  map[string]interface{}{"type": "string", "description": "令牌"},
+					"d":  map[string]interface{}{"type": "string", "description": "设备id"},
+					"v":  map[string]interface{}{"type": "integer", "format": "int64", "description": "客户端版本"},
+					"ts": map[string]interface{}{"type": "integer", "format": "int64", "description": "秒时间戳，防重放"},
+					"n":  map[string]interface{}{"type": "string", "description": "随机字符串，防重放"},
+				},
+			},
+			"Encrypted": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"e": map[string]interface{}{"type": "string", "description": "业务参数json的AES密文，base64"},
+				},
+				"required": []interface{}{"e"},
+			},
+			"Response": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"version": map[string]interface{}{"type": "integer", "format": "int64"},
+					"state":   map[string]interface{}{"type": "string", "description": "OK 为成功，否则为错误信息"},
+					"data":    map[string]interface{}{},
+					"error":   ref("Error"),
+				},
+				"required": []interface{}{"version", "state", "data"},
+			},
+			"Error": map[string]interface{}{
+				"type":        "object",
+				"description": "state 不是 OK 时的错误码，中断请求时非200状态码的body也是此结构",
+				"properties": map[string]interface{}{
+					"code":    map[string]interface{}{"type": "integer", "description": "错误码，0 成功，1 未分类错误，1000 参数错误，其他为 状态码*100 起始"},
+					"key":     map[string]interface{}{"type": "string", "description": "消息key"},
+					"message": map[string]interface{}{"type": "string"},
+					"details": map[string]interface{}{},
+				},
+				"required": []interface{}{"code", "key", "message"},
+			},
+		},
+		names: make(map[reflect.Type]string),
+	}
+
+	paths := make(map[string]interface{})
+	groups := route.Versions()
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		versions := groups[key]
+		//最新的版本为主要描述，旧版本在 x-versions 中
+		r := versions[len(versions)-1]
+		item, ok := paths[r.Url].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[r.Url] = item
+		}
+		method := strings.ToLower(r.Method)
+		if method == "" {
+			//没有指定方法的路由接收所有方法，按 post 描述
+			method = "post"
+		}
+		op := g.operation(r)
+		if len(versions) > 1 {
+			var old []interface{}
+			for _, v := range versions[:len(versions)-1] {
+				o := g.operation(v)
+				o["x-version-range"] = fmt.Sprintf("%d-%d", v.Pattern.Version, v.Pattern.MaxVersion)
+				old = append(old, o)
+			}
+			op["x-versions"] = old
+		}
+		item[method] = op
+	}
+
+	title := info.Title
+	if title == "" {
+		title = "api"
+	}
+	infoVersion := info.Version
+	if infoVersion == "" {
+		infoVersion = "1.0.0"
+	}
+	doc := map[string]interface{}{
+		"openapi": version,
+		"info": map[string]interface{}{
+			"title":       title,
+			"version":     infoVersion,
+			"description": info.Description,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"parameters": map[string]interface{}{
+				"ContentSign": map[string]interface{}{
+					"name":        "Content-Sign",
+					"in":          "header",
+					"required":    true,
+					"description": "cipher.Sign(body, AccessKeyID)，AccessKeyID 由令牌得到",
+					"schema":      map[string]interface{}{"type": "string"},
+				},
+			},
+		},
+	}
+	if len(info.Servers) > 0 {
+		servers := make([]interface{}, len(info.Servers))
+		for i, s := range info.Servers {
+			servers[i] = map[string]interface{}{"url": s}
+		}
+		doc["servers"] = servers
+	}
+	return doc
+}
+
+// JSON 生成json格式的文档
+func JSON(info Info) ([]byte, error) {
+	return json.MarshalIndent(Document(info), "", "  ")
+}
+
+// YAML 生成yaml格式的文档
+func YAML(info Info) ([]byte, error) {
+	//先转成json再转回来，得到只包含基本类型的结构
+	b, err := json.Marshal(Document(info))
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err = json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = writeYAML(&buf, v, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Handler 提供文档，路径以 .yaml 或 .yml 结尾时返回yaml，否则返回json
+func Handler(info Info) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var b []byte
+		var err error
+		if strings.HasSuffix(r.URL.Path, ".yaml") || strings.HasSuffix(r.URL.Path, ".yml") {
+			w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+			b, err = YAML(info)
+		} else {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			b, err = JSON(info)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(b)
+	}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// operation 一个路由的描述
+func (g *generator) operation(r route.Route) map[string]interface{} {
+	p := r.Pattern
+	segments := route.Segments(r.Url)
+	tag := segments[0]
+	if tag == "" {
+		tag = "default"
+	}
+	op := map[string]interface{}{
+		"operationId": operationId(r),
+		"tags":        []interface{}{tag},
+	}
+
+	var parameters []interface{}
+	//路径参数
+	for _, segment := range segments {
+		if name, ok := route.IsParam(segment); ok {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	if p.Auth == route.Enable {
+		parameters = append(parameters, map[string]interface{}{"$ref": "#/components/parameters/ContentSign"})
+	}
+
+	//请求参数
+	var request interface{} = map[string]interface{}{"type": "object"}
+	if r.RequestType() != nil {
+		request = g.schema(r.RequestType())
+	}
+	auth := ref("Auth")
+	if p.Auth == route.Enable {
+		required := []interface{}{"t"}
+		if p.Replay == route.Enable {
+			required = append(required, "ts", "n")
+		}
+		auth = map[string]interface{}{
+			"allOf": []interface{}{ref("Auth"), map[string]interface{}{"required": required}},
+		}
+	}
+	if r.Method == http.MethodGet {
+		//GET 的参数在url中
+		for _, name := range []string{"t", "d", "v", "ts", "n"} {
+			parameters = append(parameters, map[string]interface{}{
+				"name":   name,
+				"in":     "query",
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		op["description"] = "参数为url参数，结构见 x-request"
+		op["x-request"] = request
+	} else {
+		var body interface{}
+		if p.Encrypt == route.Enable {
+			body = map[string]interface{}{"allOf": []interface{}{auth, ref("Encrypted")}}
+			op["x-encrypted-request"] = request
+		} else {
+			body = map[string]interface{}{"allOf": []interface{}{auth, request}}
+		}
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": body},
+			},
+		}
+	}
+	if r.Upload != nil && r.Method != http.MethodGet {
+		//上传，data 字段为json参数，之后是文件
+		file := map[string]interface{}{"type": "string", "format": "binary"}
+		maxFiles := r.Upload.MaxFiles
+		if maxFiles <= 0 {
+			maxFiles = 1
+		}
+		if len(r.Upload.Types) > 0 {
+			file["description"] = strings.Join(r.Upload.Types, ", ")
+		}
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"multipart/form-data": map[string]interface{}{
+					"schema": map[string]interface{}{
+						"type":     "object",
+						"required": []interface{}{route.UploadField},
+						"properties": map[string]interface{}{
+							route.UploadField: map[string]interface{}{"allOf": []interface{}{auth, request}},
+							"files":           map[string]interface{}{"type": "array", "items": file, "maxItems": maxFiles},
+						},
+					},
+					"encoding": map[string]interface{}{
+						route.UploadField: map[string]interface{}{"contentType": "application/json"},
+					},
+				},
+			},
+		}
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+
+	//返回数据
+	var ok map[string]interface{}
+	if p.General == route.Enable {
+		contentType := r.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		ok = map[string]interface{}{
+			"description": "原始数据",
+			"content": map[string]interface{}{
+				contentType: map[string]interface{}{
+					"schema": map[string]interface{}{"type": "string", "format": "binary"},
+				},
+			},
+		}
+	} else {
+		var data interface{} = map[string]interface{}{}
+		if r.ResponseType() != nil {
+			data = g.schema(r.ResponseType())
+		}
+		var schema interface{} = map[string]interface{}{
+			"allOf": []interface{}{
+				ref("Response"),
+				map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"data": data},
+				},
+			},
+		}
+		content := "application/json"
+		if p.Encrypt == route.Enable {
+			content = "text/plain"
+			op["x-encrypted-response"] = schema
+			schema = map[string]interface{}{"type": "string", "description": "{version,state,data} 的AES密文，base64"}
+		}
+		ok = map[string]interface{}{
+			"description": "state 为 OK 时成功",
+			"content": map[string]interface{}{
+				content: map[string]interface{}{"schema": schema},
+			},
+		}
+		if p.Auth == route.Enable {
+			ok["headers"] = map[string]interface{}{
+				"Content-Sign": map[string]interface{}{
+					"description": "cipher.Sign(body, AccessKeyID)",
+					"schema":      map[string]interface{}{"type": "string"},
+				},
+			}
+		}
+	}
+	if r.Stream != nil {
+		//流式输出，事件格式见 route.RegisterStream
+		ok = map[string]interface{}{
+			"description": "事件流，出错时推送 event 为 error 的事件",
+			"content": map[string]interface{}{
+				r.Stream.Format.ContentType(): map[string]interface{}{
+					"schema": map[string]interface{}{"type": "string"},
+				},
+			},
+		}
+	}
+	responses := map[string]interface{}{"200": ok}
+	if p.Version > 0 || p.MaxVersion > 0 || !p.Sunset.IsZero() {
+		description := fmt.Sprintf("客户端版本低于 %d", p.Version)
+		if p.MaxVersion > 0 {
+			description = fmt.Sprintf("客户端版本不在 %d-%d 内", p.Version, p.MaxVersion)
+		}
+		if !p.Sunset.IsZero() {
+			description += "或已停用"
+		}
+		responses["410"] = map[string]interface{}{"description": description}
+	}
+	if !p.Deprecation.IsZero() {
+		op["deprecated"] = true
+	}
+	if p.Auth == route.Enable {
+		responses["403"] = map[string]interface{}{"description": "缺少数据签名"}
+		responses["401"] = map[string]interface{}{"description": "令牌已过期或已吊销"}
+		responses["406"] = map[string]interface{}{"description": "令牌或签名错误"}
+	}
+	if len(p.Roles) > 0 || len(p.Permissions) > 0 {
+		responses["403"] = map[string]interface{}{"description": "缺少数据签名或没有权限"}
+		if len(p.Roles) > 0 {
+			op["x-roles"] = p.Roles
+		}
+		if len(p.Permissions) > 0 {
+			op["x-permissions"] = p.Permissions
+		}
+	}
+	if p.Replay == route.Enable {
+		responses["409"] = map[string]interface{}{"description": "重复的请求"}
+		responses["412"] = map[string]interface{}{"description": "时间戳超出范围或缺少nonce"}
+	}
+	responses["429"] = map[string]interface{}{"description": "请求过快"}
+	op["responses"] = responses
+	return op
+}
+
+func operationId(r route.Route) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(r.Method))
+	upper := b.Len() > 0
+	for _, c := range r.Url {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			if upper && c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			b.WriteRune(c)
+			upper = false
+		} else {
+			upper = true
+		}
+	}
+	return b.String()
+}
+
+// schema 由类型生成 JSON Schema，结构体放入 components
+func (g *generator) schema(t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.object(t)
+	}
+	return map[string]interface{}{}
+}
+
+// object 结构体，有名字的放入 components 并返回引用
+func (g *generator) object(t reflect.Type) interface{} {
+	if name, ok := g.names[t]; ok {
+		return ref(name)
+	}
+	name := t.Name()
+	if name != "" {
+		//同名的类型加上序号
+		base := name
+		for i := 2; ; i++ {
+			if _, exists := g.schemas[name]; !exists {
+				break
+			}
+			name = fmt.Sprintf("%s%d", base, i)
+		}
+		g.names[t] = name
+		//先占位，防止递归
+		g.schemas[name] = map[string]interface{}{}
+	}
+
+	properties := make(map[string]interface{})
+	var required []interface{}
+	var allOf []interface{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		fieldName := f.Name
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if tag != "" {
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				fieldName = n
+			}
+		}
+		//匿名结构体展开
+		if f.Anonymous && tag == "" {
+			allOf = append(allOf, g.schema(f.Type))
+			continue
+		}
+		properties[fieldName] = g.schema(f.Type)
+		if f.Tag.Get("required") == "true" {
+			required = append(required, fieldName)
+		}
+	}
+	object := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	var s interface{} = object
+	if len(allOf) > 0 {
+		s = map[string]interface{}{"allOf": append(allOf, object)}
+	}
+	if name == "" {
+		return s
+	}
+	g.schemas[name] = s
+	return ref(name)
+}
+
+// writeYAML 输出yaml，标量使用json格式，json的字符串是合法的yaml双引号字符串
+func writeYAML(buf *bytes.Buffer, v interface{}, indent int) error {
+	pad := strings.Repeat("  ", indent)
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if len(value) == 0 {
+			buf.WriteString(pad + "{}\n")
+			return nil
+		}
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			key, _ := json.Marshal(k)
+			buf.WriteString(pad + string(key) + ":")
+			if err := writeYAMLValue(buf, value[k], indent); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if len(value) == 0 {
+			buf.WriteString(pad + "[]\n")
+			return nil
+		}
+		for _, item := range value {
+			buf.WriteString(pad + "-")
+			if err := writeYAMLValue(buf, item, indent); err != nil {
+				return err
+			}
+		}
+	default:
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(pad + string(b) + "\n")
+	}
+	return nil
+}
+
+// writeYAMLValue 输出 key: 或 - 之后的值
+func writeYAMLValue(buf *bytes.Buffer, v interface{}, indent int) error {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if len(value) == 0 {
+			buf.WriteString(" {}\n")
+			return nil
+		}
+		buf.WriteString("\n")
+		return writeYAML(buf, value, indent+1)
+	case []interface{}:
+		if len(value) == 0 {
+			buf.WriteString(" []\n")
+			return nil
+		}
+		buf.WriteString("\n")
+		return writeYAML(buf, value, indent+1)
+	default:
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(" " + string(b) + "\n")
+	}
+	return nil
+}
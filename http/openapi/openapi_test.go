@@ -0,0 +1,55 @@
+package openapi
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/qiaojun2016/basic/http/route"
+)
+
+type (
+	orderReq struct {
+		Id    int64  `json:"id" path:"id"`
+		Phone string `json:"phone" required:"true"`
+	}
+	orderResp struct {
+		Id    int64    `json:"id"`
+		Items []string `json:"items"`
+	}
+)
+
+func TestJSON(t *testing.T) {
+	route.RegisterTyped(route.Route{Method: "POST", Url: "/order/{id}"}, func(c *route.Context, req orderReq) (orderResp, error) {
+		return orderResp{Id: req.Id}, nil
+	})
+	b, err := JSON(Info{Title: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Paths      map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err = json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc.Paths["/order/{id}"]["post"]; !ok {
+		t.Fatal(string(b))
+	}
+	for _, name := range []string{"orderReq", "orderResp", "Auth", "Response"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Fatalf("missing schema %s", name)
+		}
+	}
+
+	y, err := YAML(Info{Title: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(y), `"/order/{id}":`) {
+		t.Fatal(string(y))
+	}
+}
@@ -0,0 +1,118 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"github.com/qiaojun2016/basic/badger"
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/redis"
+	"net/http"
+	"time"
+)
+
+//防重放，Pattern.Replay 开启的路由有效
+/*
+body: {
+	"t":"token",
+	"ts":1650000000, 秒时间戳，与服务器时间相差不得超过 Server.ReplayWindow
+	"n":"nonce",     随机字符串，同一个session内在 2*ReplayWindow 内不得重复
+	...
+}
+ts 和 n 在签名的body中，不能被篡改
+*/
+
+const (
+	defaultReplayWindow = 15 * time.Second
+	noncePrefix         = "basic:nonce:"
+)
+
+type (
+	// NonceStore 保存已使用的nonce
+	NonceStore interface {
+		// Seen 记录nonce并在ttl后过期，nonce 已存在时返回true
+		Seen(nonce string, ttl time.Duration) (bool, error)
+	}
+
+	// RedisNonceStore 使用redis保存nonce，多个实例共享
+	RedisNonceStore struct{}
+
+	// BadgerNonceStore 使用badger保存nonce，只在当前进程有效
+	BadgerNonceStore struct{}
+)
+
+func (RedisNonceStore) Seen(nonce string, ttl time.Duration) (bool, error) {
+	if redis.Redis == nil {
+		return false, fmt.Errorf("redis not run")
+	}
+	ok, err := redis.Redis.Client().SetNX(context.Background(), noncePrefix+nonce, 1, ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	return !ok, nil
+}
+
+func (BadgerNonceStore) Seen(nonce string, ttl time.Duration) (bool, error) {
+	if badger.Dadger == nil {
+		return false, fmt.Errorf("badger not run")
+	}
+	ok, err := badger.Dadger.SetNX([]byte(noncePrefix), []byte(nonce), []byte{1}, ttl)
+	if err != nil {
+		return false, err
+	}
+	return !ok, nil
+}
+
+// defaultNonceStore 优先使用redis，其次badger
+func defaultNonceStore() NonceStore {
+	if redis.Redis != nil {
+		return RedisNonceStore{}
+	}
+	if badger.Dadger != nil {
+		return BadgerNonceStore{}
+	}
+	return nil
+}
+
+// Replay 防重放，在 Signature 之后执行。window 为时间戳允许的误差，
+// store 为空时按 redis、badger 的顺序选择已启动的存储
+func Replay(window time.Duration, store NonceStore) Middleware {
+	if window <= 0 {
+		window = defaultReplayWindow
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) {
+			if c.Route.Pattern.Replay != Enable {
+				next(c)
+				return
+			}
+			diff := time.Since(time.Unix(c.Timestamp, 0))
+			if c.Timestamp == 0 || diff > window || diff < -window {
+				c.AbortWithError(http.StatusPreconditionFailed, ErrTimestamp.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "请求时间戳超出范围")))
+				return
+			}
+			if c.Nonce == "" {
+				c.AbortWithError(http.StatusPreconditionFailed, ErrNonceMissing.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "缺少nonce")))
+				return
+			}
+			s := store
+			if s == nil {
+				s = defaultNonceStore()
+			}
+			if s == nil {
+				c.Abort(http.StatusInternalServerError, fmt.Sprintf("%s : %s", c.Pattern, "nonce store not run"))
+				return
+			}
+			//超出时间窗口的请求已被拒绝，nonce 只需保留两个窗口
+			seen, err := s.Seen(fmt.Sprintf("%d:%s", c.Session, c.Nonce), 2*window)
+			if err != nil {
+				c.Abort(http.StatusInternalServerError, fmt.Sprintf("%s : %s", c.Pattern, err))
+				return
+			}
+			if seen {
+				c.AbortWithError(http.StatusConflict, ErrReplay.WithMessage(fmt.Sprintf("%s : %s", c.Pattern, "重复的请求")))
+				return
+			}
+			next(c)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package route
+
+import (
+	"context"
+	"net/http"
+)
+
+type (
+	// Context 一次请求的上下文，在中间件和Handle之间传递
+	Context struct {
+		Writer    http.ResponseWriter
+		Request   *http.Request
+		Pattern   string //注册的路由，见 Route.Key
+		Route     Route
+		Params    map[string]string //路径参数
+		RealIp    string
+		UserAgent string
+		Sign      string //请求的签名
+		RequestId string //请求id，在返回的 X-Request-Id 中回显
+		Data      []byte //请求参数，加密模式下为解密后的数据
+		Token     string
+		DeviceId  string
+		Version   int64
+		Encrypted string //加密模式下的业务参数密文
+		Timestamp int64  //请求的秒时间戳，防重放使用
+		Nonce     string //请求的随机字符串，防重放使用
+		Id        int64  //令牌中的id
+		Session   int64  //令牌中的session
+		AccessKey []byte //令牌的AccessKeyID，用于签名和加密
+		Grant     Grant  //用户的角色和权限，只有设置了 Pattern.Roles 或 Pattern.Permissions 的路由才有
+		Files     *Files //上传的文件，只有 RegisterUpload 注册的路由才有
+
+		Result   interface{} //handle返回的数据
+		Err      error       //handle返回的错误
+		Body     []byte      //写出的数据，通用模式为原始数据，否则为 {version,state,data} 的json
+		CacheHit bool        //结果来自缓存
+
+		aborted   bool
+		abortErr  *Error //中断请求的错误
+		streaming bool   //已经开始流式输出
+	}
+
+	// HandlerFunc 中间件链中的一步
+	HandlerFunc func(*Context)
+
+	// Middleware 中间件，调用 next 继续执行，不调用则中断
+	Middleware func(next HandlerFunc) HandlerFunc
+)
+
+// Context 请求的 context.Context，带有请求id，客户端断开或超过 Server.WriteTimeout 时取消，
+// 传给 redis.GetCtx、request.HttpGetCtx、mysql.TxBeginCtx 等
+func (c *Context) Context() context.Context {
+	return c.Request.Context()
+}
+
+// PathValue 返回路径参数，/order/{id} 的 id
+func (c *Context) PathValue(name string) string {
+	return c.Params[name]
+}
+
+// AbortWithStatus 只写出状态码并中断请求
+func (c *Context) AbortWithStatus(status int) {
+	c.aborted = true
+	c.Writer.WriteHeader(status)
+}
+
+// Aborted 请求是否已经中断
+func (c *Context) Aborted() bool {
+	return c.aborted
+}
+
+// AbortError 中断请求的错误，没有中断或 AbortWithStatus 中断时为nil
+func (c *Context) AbortError() *Error {
+	return c.abortErr
+}
+
+// Chain 按顺序组合中间件，第一个中间件最先执行
+func Chain(handler HandlerFunc, middlewares ...Middleware) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
@@ -0,0 +1,50 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mw := func(name string, abort bool) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(c *Context) {
+				order = append(order, name)
+				if abort {
+					c.Abort(http.StatusForbidden, name)
+					return
+				}
+				next(c)
+				order = append(order, name+" after")
+			}
+		}
+	}
+	handler := func(c *Context) {
+		order = append(order, "handler")
+	}
+
+	//第一个中间件最先执行，返回时顺序相反
+	Chain(handler, mw("a", false), mw("b", false), mw("c", false))(&Context{})
+	if want := []string{"a", "b", "c", "handler", "c after", "b after", "a after"}; !reflect.DeepEqual(order, want) {
+		t.Fatal(order)
+	}
+
+	//中断后不再执行之后的中间件和handler
+	order = nil
+	w := httptest.NewRecorder()
+	c := &Context{Writer: w}
+	Chain(handler, mw("a", false), mw("b", true), mw("c", false))(c)
+	if want := []string{"a", "b", "a after"}; !reflect.DeepEqual(order, want) || !c.Aborted() || w.Code != http.StatusForbidden {
+		t.Fatal(order, w.Code)
+	}
+
+	//没有中间件时直接执行handler
+	order = nil
+	Chain(handler)(&Context{})
+	if !reflect.DeepEqual(order, []string{"handler"}) {
+		t.Fatal(order)
+	}
+}
@@ -0,0 +1,198 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//错误码
+/*
+handle 返回的错误，状态码为200，写入返回数据：
+	{"version":1,"state":"消息","data":null,"error":{"code":1,"key":"unknown","message":"消息","details":...}}
+	*Error         原样输出 code、key、message、details
+	其他 error     code 为 CodeUnknown，message 为 err.Error()
+	解析参数失败   code 为 CodeInvalidParam，只有 RegisterTyped 的路由
+	文件错误       code 为 CodeInvalidFile，只有 RegisterUpload 的路由
+	通用模式的路由没有返回数据，错误按状态码500输出
+
+中间件中断请求时，状态码不是200，body 为同样结构的json，data 为 null：
+	400 CodeBadRequest        url参数、版本号错误
+	400 CodeDecryptFailed     解密失败，密文或补码错误
+	401 CodeTokenExpired      令牌已过期，使用刷新令牌换取新的令牌
+	401 CodeTokenRevoked      令牌已吊销，需要重新登录
+	403 CodeUserAgent         User-Agent 错误
+	403 CodeSignMissing       缺少 Content-Sign
+	403 CodeForbidden         没有 Pattern.Roles、Pattern.Permissions 要求的角色或权限
+	403 CodeIpDenied          ip 不在允许列表中或在拒绝列表中
+	403 CodeIpBanned          ip 违规次数过多，被临时封禁
+	406 CodeTokenMissing      缺少令牌
+	406 CodeTokenInvalid      令牌错误
+	406 CodeSignMismatch      签名校验失败
+	406 CodeEncryptedMissing  缺少加密数据
+	409 CodeReplay            重复的请求
+	410 CodeVersionGone       客户端版本过低
+	412 CodeTimestamp         时间戳超出范围
+	412 CodeNonceMissing      缺少nonce
+	413 CodeTooLarge          body 超出长度
+	429 CodeTooManyRequests   请求过快
+	500 CodeInternal          服务内部错误
+	504 CodeTimeout           处理超过 Server.WriteTimeout
+客户端断开时不再写出，状态码记为499
+其他状态码的 code 为 状态码*100
+*/
+
+const (
+	CodeOK           = 0    //成功
+	CodeUnknown      = 1    //handle 返回的普通错误
+	CodeInvalidParam = 1000 //请求参数解析或校验失败
+	CodeInvalidFile  = 1001 //上传的文件不符合限制或摘要错误
+
+	CodeBadRequest       = 40000
+	CodeDecryptFailed    = 40001
+	CodeTokenExpired     = 40100
+	CodeTokenRevoked     = 40101
+	CodeUserAgent        = 40300
+	CodeSignMissing      = 40301
+	CodeForbidden        = 40302
+	CodeIpDenied         = 40303
+	CodeIpBanned         = 40304
+	CodeTokenMissing     = 40600
+	CodeTokenInvalid     = 40601
+	CodeSignMismatch     = 40602
+	CodeEncryptedMissing = 40603
+	CodeReplay           = 40900
+	CodeVersionGone      = 41000
+	CodeTimestamp        = 41200
+	CodeNonceMissing     = 41201
+	CodeTooLarge         = 41300
+	CodeTooManyRequests  = 42900
+	CodeInternal         = 50000
+	CodeTimeout          = 50400
+)
+
+type (
+	// Error 带错误码的错误，handle 可以直接返回
+	Error struct {
+		Code    int         `json:"code"`              //错误码，客户端根据错误码判断
+		Key     string      `json:"key"`               //消息key，客户端用于多语言
+		Message string      `json:"message"`           //默认消息
+		Details interface{} `json:"details,omitempty"` //附加信息
+	}
+
+	// envelope 中断请求时的返回数据，与 {version,state,data} 的结构相同
+	envelope struct {
+		Version int64       `json:"version"`
+		State   string      `json:"state"`
+		Data    interface{} `json:"data"`
+		Error   *Error      `json:"error"`
+	}
+)
+
+// 中间件使用的错误
+var (
+	ErrUserAgent        = NewError(CodeUserAgent, "user_agent", "User-Agent 错误")
+	ErrSignMissing      = NewError(CodeSignMissing, "sign_missing", "缺少数据签名")
+	ErrForbidden        = NewError(CodeForbidden, "forbidden", "没有权限")
+	ErrIpDenied         = NewError(CodeIpDenied, "ip_denied", "禁止访问")
+	ErrIpBanned         = NewError(CodeIpBanned, "ip_banned", "访问过于频繁，已被临时封禁")
+	ErrTokenMissing     = NewError(CodeTokenMissing, "token_missing", "缺少令牌")
+	ErrTokenInvalid     = NewError(CodeTokenInvalid, "token_invalid", "令牌错误")
+	ErrTokenExpired     = NewError(CodeTokenExpired, "token_expired", "令牌已过期")
+	ErrTokenRevoked     = NewError(CodeTokenRevoked, "token_revoked", "令牌已吊销")
+	ErrSignMismatch     = NewError(CodeSignMismatch, "sign_mismatch", "指纹检验失败")
+	ErrEncryptedMissing = NewError(CodeEncryptedMissing, "encrypted_missing", "缺少加密数据")
+	ErrDecryptFailed    = NewError(CodeDecryptFailed, "decrypt_failed", "解密失败")
+	ErrReplay           = NewError(CodeReplay, "replay", "重复的请求")
+	ErrVersionGone      = NewError(CodeVersionGone, "version_gone", "版本过低")
+	ErrTimestamp        = NewError(CodeTimestamp, "timestamp", "请求时间戳超出范围")
+	ErrNonceMissing     = NewError(CodeNonceMissing, "nonce_missing", "缺少nonce")
+	ErrTooLarge         = NewError(CodeTooLarge, "too_large", "读取body错误")
+	ErrTooManyRequests  = NewError(CodeTooManyRequests, "too_many_requests", "请求过快")
+	ErrTimeout          = NewError(CodeTimeout, "timeout", "处理超时")
+)
+
+// NewError 创建错误
+func NewError(code int, key, message string) *Error {
+	return &Error{Code: code, Key: key, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// WithMessage 返回消息不同的副本，错误码和key不变
+func (e *Error) WithMessage(message string) *Error {
+	err := *e
+	err.Message = message
+	return &err
+}
+
+// WithDetails 返回带附加信息的副本
+func (e *Error) WithDetails(details interface{}) *Error {
+	err := *e
+	err.Details = details
+	return &err
+}
+
+// Is 错误码相同即为同一个错误，可以用 errors.Is(err, ErrTokenInvalid) 判断
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// AsError 把错误转换为 *Error，普通错误的错误码为 CodeUnknown
+func AsError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{Code: CodeUnknown, Key: "unknown", Message: err.Error()}
+}
+
+// statusError 中断请求时状态码对应的错误
+func statusError(status int, message string) *Error {
+	code := status * 100
+	switch status {
+	case http.StatusBadRequest:
+		code = CodeBadRequest
+	case http.StatusInternalServerError:
+		code = CodeInternal
+	}
+	key := strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	if key == "" {
+		key = fmt.Sprint(status)
+	}
+	return &Error{Code: code, Key: key, Message: message}
+}
+
+// Abort 输出错误并中断请求，错误码为状态码对应的错误码
+func (c *Context) Abort(status int, errStr string) {
+	c.AbortWithError(status, statusError(status, errStr))
+}
+
+// AbortWithError 以json输出带错误码的错误并中断请求
+func (c *Context) AbortWithError(status int, err *Error) {
+	fmt.Println(fmt.Sprintf("%s %d %s", c.RequestId, err.Code, err.Message))
+	c.aborted = true
+	c.abortErr = err
+	b, e := json.Marshal(envelope{
+		Version: c.Route.Pattern.Version,
+		State:   err.Message,
+		Error:   err,
+	})
+	if e != nil {
+		http.Error(c.Writer, err.Message, status)
+		return
+	}
+	h := c.Writer.Header()
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	c.Writer.WriteHeader(status)
+	_, _ = c.Writer.Write(b)
+}
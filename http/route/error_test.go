@@ -0,0 +1,43 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAsError(t *testing.T) {
+	if e := AsError(fmt.Errorf("库存不足")); e.Code != CodeUnknown || e.Message != "库存不足" {
+		t.Fatal(e)
+	}
+	wrapped := fmt.Errorf("order: %w", ErrTokenInvalid.WithMessage("令牌过期"))
+	if e := AsError(wrapped); e.Code != CodeTokenInvalid || e.Message != "令牌过期" {
+		t.Fatal(e)
+	}
+	if !errors.Is(wrapped, ErrTokenInvalid) || errors.Is(wrapped, ErrTokenMissing) {
+		t.Fatal("errors.Is by code")
+	}
+}
+
+func TestContext_Abort(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := &Context{Writer: w, Route: Route{Pattern: Pattern{Version: 2}}}
+	c.Abort(http.StatusTooManyRequests, "请求过快")
+	if !c.Aborted() || w.Code != http.StatusTooManyRequests {
+		t.Fatal(w.Code)
+	}
+	var body struct {
+		Version int64  `json:"version"`
+		State   string `json:"state"`
+		Error   *Error `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Version != 2 || body.State != "请求过快" || body.Error.Code != CodeTooManyRequests || body.Error.Key != "too_many_requests" {
+		t.Fatal(w.Body.String())
+	}
+}
@@ -0,0 +1,51 @@
+package route
+
+type (
+	// Grant 用户的角色和权限，由 Server.RoleResolver 根据令牌中的id得到
+	Grant struct {
+		Roles       []string
+		Permissions []string
+	}
+)
+
+// HasRole 是否有角色
+func (g Grant) HasRole(role string) bool {
+	for _, r := range g.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission 是否有权限
+func (g Grant) HasPermission(permission string) bool {
+	for _, p := range g.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow 是否满足路由的要求：Pattern.Roles 满足其一，Pattern.Permissions 全部满足
+func (g Grant) Allow(p Pattern) bool {
+	if len(p.Roles) > 0 {
+		ok := false
+		for _, role := range p.Roles {
+			if g.HasRole(role) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, permission := range p.Permissions {
+		if !g.HasPermission(permission) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,16 +1,32 @@
 // Package route 路由模式配置
 package route
 
+import "time"
+
 type (
 	PatternType int64
 	Pattern     struct {
 		Auth        PatternType //认证
 		Cache       PatternType //缓存
-		CacheExpire int64       //缓存保留时间单位秒，当Cache开启的时候有效
+		CacheExpire int64       //缓存保留时间单位秒，当Cache开启的时候有效，0为不过期
+		CacheTags   []string    //缓存分组，用于按分组清除缓存
 		Encrypt     PatternType //加密
 		UserAgent   PatternType //user-agent
 		General     PatternType //通用模式
-		Version     int64       //内部版本
+		Replay      PatternType //防重放，需要开启认证
+		Rate        float64     //路由每秒的请求数，0为不限制，所有用户共享
+		Burst       int         //路由的令牌桶大小，Rate 大于0时有效，默认为 Rate
+		UserRate    float64     //每个用户每秒的请求数，按令牌中的id限制，需要开启认证
+		UserBurst   int         //每个用户的令牌桶大小，默认为 UserRate
+		Compress    PatternType //响应压缩，默认开启
+		Roles       []string    //需要的角色，满足其一即可，需要开启认证
+		Permissions []string    //需要的权限，需要全部满足，需要开启认证
+		Version     int64       //内部版本，客户端版本低于此版本时返回410
+		MaxVersion  int64       //支持的最高客户端版本，0为不限制。同一个url可以注册多个版本范围不重叠的路由，按请求的 v 选择
+		Deprecation time.Time   //弃用时间，不为空时返回 Deprecation 头
+		Sunset      time.Time   //停用时间，不为空时返回 Sunset 头，之后返回410
+		AllowIps    []string    //允许的ip或网段，在 Server.AllowIps 之后检查，为空时不限制。多个版本时使用最新版本的配置
+		DenyIps     []string    //拒绝的ip或网段，在 Server.DenyIps 之后检查
 	}
 )
 
@@ -28,6 +44,10 @@ const (
 	UserAgentDisable
 	// GeneralDisable 通用模式
 	GeneralDisable
+	// ReplayDisable 防重放
+	ReplayDisable
+	// CompressDisable 压缩
+	CompressDisable
 )
 
 func (p PatternType) String() string {
@@ -53,6 +73,12 @@ func (p PatternType) String() string {
 	//	通用模式
 	case GeneralDisable:
 		return "关闭通用模式"
+	//	防重放
+	case ReplayDisable:
+		return "关闭防重放"
+	//	压缩
+	case CompressDisable:
+		return "关闭压缩"
 	}
 
 	return "N/A"
@@ -1,6 +1,14 @@
 package route
 
-import "log"
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
 
 type (
 	// RouteMap 路由存储结构
@@ -17,34 +25,128 @@ type (
 	// UserAgentHandle 返回user-agent的签名。agent,id,数据
 	UserAgentHandle func(string, string, []byte) (interface{}, error)
 
+	// ContextHandle 携带请求上下文的签名，可以获取路径参数
+	ContextHandle func(*Context) (interface{}, error)
+
 	// Route 一个路由的结构
 	Route struct {
-		Url             string
+		Method          string //请求方法，为空时接收所有方法
+		Url             string //支持路径参数，如 /order/{id}
 		ContentType     string
 		Pattern         Pattern
+		Middlewares     []Middleware //只作用于该路由的中间件，在全局中间件之后执行
+		Upload          *Upload      //上传的限制，RegisterUpload 注册的路由才有
+		Stream          *Stream      //流式输出的配置，RegisterStream 注册的路由才有
 		handle          Handle
 		ipHandle        IpHandle
 		sessionHandle   SessionHandle
 		userAgentHandle UserAgentHandle
+		contextHandle   ContextHandle
+		requestType     reflect.Type //RegisterTyped 的请求参数类型
+		responseType    reflect.Type //RegisterTyped 的返回数据类型
 	}
 )
 
 //var Routes route
 var routes routeMap
 
+// Segments 拆分路由，{name} 为路径参数
+func Segments(url string) []string {
+	return strings.Split(strings.Trim(url, "/"), "/")
+}
+
+// IsParam 是否是路径参数，返回参数名
+func IsParam(segment string) (string, bool) {
+	if len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// Shape 去掉参数名，用于判断路由是否重复，/order/{id} 与 /order/{no} 是同一个路由
+func Shape(url string) string {
+	segments := Segments(url)
+	for i, segment := range segments {
+		if _, ok := IsParam(segment); ok {
+			segments[i] = "{}"
+		}
+	}
+	shape := "/" + strings.Join(segments, "/")
+	if len(shape) > 1 && strings.HasSuffix(url, "/") {
+		shape += "/"
+	}
+	return shape
+}
+
+// Key 路由表的key，指定了方法时为 "METHOD url"，否则为 url。
+// 设置了 Pattern.MaxVersion 的旧版本加上版本范围，如 "POST /order v1-2"
+func (r Route) Key() string {
+	key := r.Url
+	if r.Method != "" {
+		key = fmt.Sprintf("%s %s", r.Method, r.Url)
+	}
+	if r.Pattern.MaxVersion > 0 {
+		key = fmt.Sprintf("%s v%d-%d", key, r.Pattern.Version, r.Pattern.MaxVersion)
+	}
+	return key
+}
+
+// Covers 客户端版本是否在路由的版本范围内
+func (r Route) Covers(version int64) bool {
+	return version >= r.Pattern.Version && (r.Pattern.MaxVersion == 0 || version <= r.Pattern.MaxVersion)
+}
+
+// overlap 两个路由的版本范围是否重叠
+func overlap(a, b Pattern) bool {
+	if a.MaxVersion > 0 && a.MaxVersion < b.Version {
+		return false
+	}
+	if b.MaxVersion > 0 && b.MaxVersion < a.Version {
+		return false
+	}
+	return true
+}
+
 // Put 向路由表注册路由
 func (r routeMap) put(route Route) {
 	if route.handle == nil &&
 		route.ipHandle == nil &&
 		route.sessionHandle == nil &&
-		route.userAgentHandle == nil {
+		route.userAgentHandle == nil &&
+		route.contextHandle == nil {
 		//存在，结束程序
 		log.Panicf("'%s' handle is nill", route.Url)
 	}
-	//检查是否存在路由
-	if _, ok := r[route.Url]; ok {
-		//存在，结束程序
-		log.Panicf("'%s' redeclared in this gateway", route.Url)
+	route.Method = strings.ToUpper(route.Method)
+	//检查路径参数
+	names := make(map[string]struct{})
+	for _, segment := range Segments(route.Url) {
+		if strings.ContainsAny(segment, "{}") {
+			name, ok := IsParam(segment)
+			if !ok {
+				log.Panicf("'%s' bad path parameter '%s'", route.Url, segment)
+			}
+			if _, ok = names[name]; ok {
+				log.Panicf("'%s' duplicate path parameter '%s'", route.Url, name)
+			}
+			names[name] = struct{}{}
+		}
+	}
+	if route.Pattern.MaxVersion > 0 && route.Pattern.MaxVersion < route.Pattern.Version {
+		log.Panicf("'%s' max version is less than version", route.Url)
+	}
+	//检查是否存在路由，方法相同且去掉参数名后相同即为重复，版本范围不重叠的是同一个路由的多个版本
+	for _, exist := range r {
+		if exist.Method == route.Method && Shape(exist.Url) == Shape(route.Url) {
+			if overlap(exist.Pattern, route.Pattern) {
+				//存在，结束程序
+				log.Panicf("'%s' redeclared in this gateway", route.Key())
+			}
+			//解析参数时还不知道版本，多个版本的url、上传、流式输出必须一致
+			if exist.Url != route.Url || (exist.Upload == nil) != (route.Upload == nil) || (exist.Stream == nil) != (route.Stream == nil) {
+				log.Panicf("'%s' versions must have the same url, upload and stream", route.Key())
+			}
+		}
 	}
 	if route.Pattern.Auth == None { // 认证
 		// 默认token认证
@@ -66,34 +168,148 @@ func (r routeMap) put(route Route) {
 		// 默认不使用通用模式
 		route.Pattern.General = GeneralDisable
 	}
-	r[route.Url] = route
+	if route.Pattern.Replay == None { // 防重放
+		// 默认不防重放
+		route.Pattern.Replay = ReplayDisable
+	}
+	if route.Pattern.Replay == Enable && route.Pattern.Auth != Enable {
+		// 时间戳和nonce需要签名保护
+		log.Panicf("'%s' replay requires auth", route.Url)
+	}
+	if len(route.Pattern.Roles) > 0 || len(route.Pattern.Permissions) > 0 {
+		// 角色来自令牌中的id
+		if route.Pattern.Auth != Enable {
+			log.Panicf("'%s' roles require auth", route.Url)
+		}
+	}
+	if route.Pattern.Compress == None { // 压缩
+		// 默认压缩
+		route.Pattern.Compress = Enable
+	}
+	if route.Pattern.Rate > 0 && route.Pattern.Burst <= 0 { // 限流
+		route.Pattern.Burst = int(math.Ceil(route.Pattern.Rate))
+	}
+	if route.Pattern.UserRate > 0 {
+		// 用户id来自令牌
+		if route.Pattern.Auth != Enable {
+			log.Panicf("'%s' user rate requires auth", route.Url)
+		}
+		if route.Pattern.UserBurst <= 0 {
+			route.Pattern.UserBurst = int(math.Ceil(route.Pattern.UserRate))
+		}
+	}
+	if route.Pattern.Encrypt == Enable {
+		// 加密的密钥来自令牌，必须开启认证
+		if route.Pattern.Auth != Enable {
+			log.Panicf("'%s' encrypt requires auth", route.Url)
+		}
+		// 通用模式直接输出原始数据，不支持加密
+		if route.Pattern.General == Enable {
+			log.Panicf("'%s' encrypt is not supported in general pattern", route.Url)
+		}
+	}
+	if route.Upload != nil {
+		// 文件不经过加密和缓存
+		if route.Method == http.MethodGet {
+			log.Panicf("'%s' upload does not support GET", route.Url)
+		}
+		if route.Pattern.Encrypt == Enable || route.Pattern.Cache == Enable {
+			log.Panicf("'%s' upload does not support encrypt or cache", route.Url)
+		}
+	}
+	if route.Stream != nil {
+		// 事件逐个写出，不能整体加密、缓存
+		if route.Pattern.Encrypt == Enable || route.Pattern.Cache == Enable || route.Pattern.General == Enable {
+			log.Panicf("'%s' stream does not support encrypt, cache or general pattern", route.Url)
+		}
+	}
+	r[route.Key()] = route
 }
 
-// All 返回路由表
+// All 返回路由表，key 见 Route.Key
 func All() map[string]Route {
 	return routes
 }
 
+// Reset 清空路由表，用于测试之间隔离注册的路由
+func Reset() {
+	routes = routeMap{}
+}
+
+// Snapshot 复制当前的路由表，与 Restore 配合在测试结束时恢复，保留 init 中注册的路由
+func Snapshot() map[string]Route {
+	snapshot := make(map[string]Route, len(routes))
+	for key, route := range routes {
+		snapshot[key] = route
+	}
+	return snapshot
+}
+
+// Restore 恢复 Snapshot 保存的路由表
+func Restore(snapshot map[string]Route) {
+	restored := routeMap{}
+	for key, route := range snapshot {
+		restored[key] = route
+	}
+	routes = restored
+}
+
+// Versions 按url分组的路由表，key 为不带版本范围的 Route.Key，同一个url的多个版本按 Pattern.Version 排序
+func Versions() map[string][]Route {
+	groups := make(map[string][]Route)
+	for _, route := range routes {
+		key := route.Url
+		if route.Method != "" {
+			key = fmt.Sprintf("%s %s", route.Method, route.Url)
+		}
+		groups[key] = append(groups[key], route)
+	}
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].Pattern.Version < group[j].Pattern.Version
+		})
+	}
+	return groups
+}
+
+// Register 注册handle
+//
+// Deprecated: 使用 RegisterTyped
 func (r Route) Register(handle Handle) {
 	r.handle = handle
 	routes.put(r)
 }
 
+// IpRegister 注册携带ip的handle
+//
+// Deprecated: 使用 RegisterTyped，ip 从 Context.RealIp 获取
 func (r Route) IpRegister(ipHandle IpHandle) {
 	r.ipHandle = ipHandle
 	routes.put(r)
 }
 
+// SessionRegister 注册携带session的handle
+//
+// Deprecated: 使用 RegisterTyped，session 从 Context.SessionId 获取
 func (r Route) SessionRegister(sessionHandle SessionHandle) {
 	r.sessionHandle = sessionHandle
 	routes.put(r)
 }
 
+// UserAgentRegister 注册携带User-Agent的handle
+//
+// Deprecated: 使用 RegisterTyped，User-Agent 从 Context.UserAgent 获取
 func (r Route) UserAgentRegister(userAgentHandle UserAgentHandle) {
 	r.userAgentHandle = userAgentHandle
 	routes.put(r)
 }
 
+// ContextRegister 注册携带请求上下文的handle
+func (r Route) ContextRegister(contextHandle ContextHandle) {
+	r.contextHandle = contextHandle
+	routes.put(r)
+}
+
 func (r Route) Handle() Handle {
 	return r.handle
 }
@@ -110,6 +326,20 @@ func (r Route) UserAgentHandle() UserAgentHandle {
 	return r.userAgentHandle
 }
 
+func (r Route) ContextHandle() ContextHandle {
+	return r.contextHandle
+}
+
+// RequestType RegisterTyped 注册的请求参数类型，其他方式注册时为nil
+func (r Route) RequestType() reflect.Type {
+	return r.requestType
+}
+
+// ResponseType RegisterTyped 注册的返回数据类型，其他方式注册时为nil
+func (r Route) ResponseType() reflect.Type {
+	return r.responseType
+}
+
 func init() {
 	routes = routeMap{}
 }
@@ -0,0 +1,241 @@
+package route
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/qiaojun2016/basic/cipher"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+//流式输出，Route.Stream 不为空的路由由handle通过 StreamWriter 推送事件
+/*
+SSE（text/event-stream），开启认证时 sign 为 cipher.Sign(data, AccessKeyID)：
+id: 1
+event: progress
+sign: signature
+data: {"done":10}
+
+NDJSON（application/x-ndjson），每行一个事件：
+{"id":1,"event":"progress","data":{"done":10},"sign":"signature"}
+
+handle 在开始输出（第一个事件或心跳）之前返回错误时，按普通请求返回 {version,state,data,error}；
+之后返回错误时推送 event 为 error 的事件，data 为 route.Error。
+客户端断开时 c.Context() 取消，Send 返回 ctx 的错误
+*/
+
+const (
+	// StreamSSE Server-Sent Events
+	StreamSSE StreamFormat = iota + 1
+	// StreamNDJSON 每行一个json
+	StreamNDJSON
+)
+
+// EventError handle 出错时推送的事件
+const EventError = "error"
+
+type (
+	// StreamFormat 流式输出的格式
+	StreamFormat int
+
+	// Stream 流式输出的配置
+	Stream struct {
+		Format      StreamFormat  //格式，默认 StreamSSE
+		MaxDuration time.Duration //最长持续时间，超过后 c.Context() 取消，0为不限制
+		Heartbeat   time.Duration //没有事件时的心跳间隔，防止代理断开空闲连接，0为不发送
+	}
+
+	// StreamWriter 推送事件，可以在多个协程中使用
+	StreamWriter struct {
+		c       *Context
+		format  StreamFormat
+		flusher http.Flusher
+		mu      sync.Mutex
+		seq     int64
+		last    time.Time
+	}
+
+	// StreamHandle 流式输出的handle
+	StreamHandle[Req any] func(c *Context, req Req, s *StreamWriter) error
+
+	// streamEvent NDJSON 的一行
+	streamEvent struct {
+		Id    int64           `json:"id"`
+		Event string          `json:"event,omitempty"`
+		Data  json.RawMessage `json:"data"`
+		Sign  string          `json:"sign,omitempty"`
+	}
+)
+
+// ContentType 格式对应的 Content-Type
+func (f StreamFormat) ContentType() string {
+	if f == StreamNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/event-stream"
+}
+
+// NewStreamWriter 创建推送器，第一次推送时写出状态码和header
+func NewStreamWriter(c *Context, format StreamFormat) *StreamWriter {
+	s := &StreamWriter{c: c, format: format}
+	s.flusher, _ = c.Writer.(http.Flusher)
+	return s
+}
+
+// Send 推送一个事件，event 为空时 SSE 客户端收到的是 message 事件
+func (s *StreamWriter) Send(event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.send(event, b)
+}
+
+func (s *StreamWriter) send(event string, data []byte) error {
+	if err := s.c.Context().Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start()
+	s.seq++
+	var sign string
+	if s.c.Route.Pattern.Auth == Enable {
+		sign = cipher.Sign(data, s.c.AccessKey)
+	}
+	var buf bytes.Buffer
+	if s.format == StreamNDJSON {
+		b, err := json.Marshal(streamEvent{Id: s.seq, Event: event, Data: data, Sign: sign})
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	} else {
+		_, _ = fmt.Fprintf(&buf, "id: %d\n", s.seq)
+		if event != "" {
+			_, _ = fmt.Fprintf(&buf, "event: %s\n", event)
+		}
+		if sign != "" {
+			_, _ = fmt.Fprintf(&buf, "sign: %s\n", sign)
+		}
+		//json中没有换行，一行data即可
+		_, _ = fmt.Fprintf(&buf, "data: %s\n\n", data)
+	}
+	return s.write(buf.Bytes())
+}
+
+// Heartbeat 发送心跳，SSE 为注释行，NDJSON 为空行
+func (s *StreamWriter) Heartbeat() error {
+	if err := s.c.Context().Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start()
+	if s.format == StreamNDJSON {
+		return s.write([]byte("\n"))
+	}
+	return s.write([]byte(": ping\n\n"))
+}
+
+// Started 是否已经开始输出
+func (s *StreamWriter) Started() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.c.streaming
+}
+
+// start 写出header，调用时已经加锁
+func (s *StreamWriter) start() {
+	if s.c.streaming {
+		return
+	}
+	s.c.streaming = true
+	h := s.c.Writer.Header()
+	h.Set("Content-Type", s.format.ContentType()+"; charset=utf-8")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	s.c.Writer.WriteHeader(http.StatusOK)
+}
+
+// write 写出并立即发送，调用时已经加锁
+func (s *StreamWriter) write(b []byte) error {
+	s.last = time.Now()
+	if _, err := s.c.Writer.Write(b); err != nil {
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
+
+// heartbeat 空闲超过 interval 时发送心跳，直到 ctx 结束
+func (s *StreamWriter) heartbeat(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			idle := time.Since(s.last) >= interval
+			s.mu.Unlock()
+			if idle && s.Heartbeat() != nil {
+				return
+			}
+		}
+	}
+}
+
+// Streaming 是否已经开始流式输出，开始之后不再写出 Body
+func (c *Context) Streaming() bool {
+	return c.streaming
+}
+
+// RegisterStream 注册流式输出的路由，Route.Stream 为空时为 SSE。
+// 请求参数与 RegisterTyped 相同，handle 通过 s 推送事件，返回时结束输出
+func RegisterStream[Req any](r Route, handle StreamHandle[Req]) {
+	if r.Stream == nil {
+		r.Stream = &Stream{}
+	}
+	if r.Stream.Format == 0 {
+		r.Stream.Format = StreamSSE
+	}
+	r.requestType = reflect.TypeOf((*Req)(nil)).Elem()
+	stream := *r.Stream
+	r.contextHandle = func(c *Context) (interface{}, error) {
+		var req Req
+		if err := decode(c, &req); err != nil {
+			return nil, &Error{Code: CodeInvalidParam, Key: "invalid_param", Message: err.Error()}
+		}
+		s := NewStreamWriter(c, stream.Format)
+		if stream.Heartbeat > 0 {
+			ctx, cancel := context.WithCancel(c.Context())
+			done := make(chan struct{})
+			go func() {
+				s.heartbeat(ctx, stream.Heartbeat)
+				close(done)
+			}()
+			//返回之前等待心跳结束，之后不能再写出
+			defer func() {
+				cancel()
+				<-done
+			}()
+		}
+		err := handle(c, req, s)
+		if err != nil && s.Started() && c.Context().Err() == nil {
+			//已经开始输出，错误作为事件推送
+			_ = s.Send(EventError, AsError(err))
+			return nil, nil
+		}
+		return nil, err
+	}
+	routes.put(r)
+}
@@ -0,0 +1,98 @@
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/qiaojun2016/basic/id"
+	"github.com/qiaojun2016/basic/verify"
+	"reflect"
+	"strconv"
+)
+
+// CallerId 令牌中的id，与 Handle 的id参数相同
+func (c *Context) CallerId() string {
+	return id.SId.ToString(c.Id)
+}
+
+// SessionId 令牌中的session，与 SessionHandle 的session参数相同
+func (c *Context) SessionId() string {
+	return id.SId.ToString(c.Session)
+}
+
+// RegisterTyped 注册泛型handle，代替 Handle、IpHandle、SessionHandle、UserAgentHandle。
+// 请求参数自动解析到 Req 并按 verify 的 required 规则校验，
+// 带 path 标签的字段从路径参数中取值，如 `path:"id"`。
+// 调用者id、session、ip、User-Agent 从 Context 中获取，c.Context() 用于传递请求id和截止时间
+func RegisterTyped[Req, Resp any](r Route, handle func(*Context, Req) (Resp, error)) {
+	r.requestType = reflect.TypeOf((*Req)(nil)).Elem()
+	r.responseType = reflect.TypeOf((*Resp)(nil)).Elem()
+	r.contextHandle = func(c *Context) (interface{}, error) {
+		var req Req
+		if err := decode(c, &req); err != nil {
+			return nil, &Error{Code: CodeInvalidParam, Key: "invalid_param", Message: err.Error()}
+		}
+		return handle(c, req)
+	}
+	routes.put(r)
+}
+
+// decode 解析请求参数
+func decode(c *Context, req interface{}) error {
+	v := reflect.ValueOf(req).Elem()
+	if v.Kind() != reflect.Struct {
+		if len(c.Data) == 0 {
+			return nil
+		}
+		return json.Unmarshal(c.Data, req)
+	}
+	//路径参数在校验之前填充
+	if len(c.Params) > 0 {
+		if err := json.Unmarshal(c.Data, req); err != nil {
+			return err
+		}
+		if err := pathDecode(c.Params, v); err != nil {
+			return err
+		}
+		b, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		return verify.Unmarshal(b, req)
+	}
+	return verify.Unmarshal(c.Data, req)
+}
+
+// pathDecode 把路径参数写入带 path 标签的字段
+func pathDecode(params map[string]string, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("path")
+		if name == "" {
+			continue
+		}
+		value, ok := params[name]
+		if !ok {
+			continue
+		}
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i64, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("path parameter %s: %s", name, err)
+			}
+			field.SetInt(i64)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			u64, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("path parameter %s: %s", name, err)
+			}
+			field.SetUint(u64)
+		default:
+			return fmt.Errorf("path parameter %s: 暂不支持%s", name, field.Kind())
+		}
+	}
+	return nil
+}
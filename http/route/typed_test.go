@@ -0,0 +1,27 @@
+package route
+
+import "testing"
+
+func Test_decode(t *testing.T) {
+	type order struct {
+		Id    int64  `json:"id" path:"id"`
+		Phone string `json:"phone" required:"true"`
+	}
+	c := &Context{
+		Params: map[string]string{"id": "12"},
+		Data:   []byte(`{"t":"token","phone":"15166077180"}`),
+	}
+	var req order
+	if err := decode(c, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Id != 12 || req.Phone != "15166077180" {
+		t.Fatalf("%+v", req)
+	}
+
+	//required 校验
+	c.Data = []byte(`{"t":"token"}`)
+	if err := decode(c, &order{}); err == nil {
+		t.Fatal("expected required error")
+	}
+}
@@ -0,0 +1,236 @@
+package route
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+//上传，Route.Upload 不为空的路由接收 multipart/form-data
+/*
+第一个part必须是名为 data 的表单字段，内容与普通请求的body相同，签名计算的是这个字段：
+data: {
+	"t":"token",
+	"d":"deviceId",
+	"files":{"avatar":"sha256(文件内容)"}, 开启认证时每个文件都必须有摘要
+	...业务参数
+}
+之后是文件part，在handle中按顺序流式读取，文件读完时校验摘要、大小，不符合时读取返回错误。
+文件较大时需要调大 Server.ReadTimeout、Server.WriteTimeout
+*/
+
+const (
+	// UploadField 上传请求中参数字段的名称
+	UploadField = "data"
+
+	defaultMaxFileBytes = 10 << 20
+	sniffLen            = 512
+)
+
+type (
+	// Upload 上传限制
+	Upload struct {
+		MaxFileBytes int64    //单个文件的最大字节数，默认10M
+		MaxFiles     int      //最多文件数，默认1
+		Types        []string //允许的类型，如 image/png、image/*，为空时不限制，类型根据文件内容判断
+	}
+
+	// Storage 保存上传的文件，oss.Oss、fileServer.FileServer 实现了此接口
+	Storage interface {
+		// Save 保存文件，返回文件的地址或key
+		Save(ctx context.Context, key, contentType string, r io.Reader) (string, error)
+	}
+
+	// Files 请求中的文件，只能按顺序读取一次
+	Files struct {
+		reader  *multipart.Reader
+		upload  Upload
+		digests map[string]string //开启认证时文件的摘要
+		count   int
+		current *File
+	}
+
+	// File 一个文件，读取到 io.EOF 时已经通过大小和摘要的校验
+	File struct {
+		Field       string //表单字段名
+		Name        string //文件名
+		ContentType string //根据内容判断的类型
+		reader      io.Reader
+		part        *multipart.Part
+		hash        hash.Hash
+		digest      string
+		max         int64
+		size        int64
+	}
+
+	// SavedFile 保存之后的文件
+	SavedFile struct {
+		Field       string `json:"field"`
+		Name        string `json:"name"`
+		ContentType string `json:"contentType"`
+		Key         string `json:"key"` //Storage.Save 返回的地址或key
+		Size        int64  `json:"size"`
+	}
+
+	// UploadHandle 上传的handle
+	UploadHandle[Req, Resp any] func(c *Context, req Req, files *Files) (Resp, error)
+)
+
+// ErrUpload 文件不符合 Upload 的限制或摘要错误
+var ErrUpload = NewError(CodeInvalidFile, "invalid_file", "文件错误")
+
+// NewFiles 创建文件读取器，digests 为空时不校验摘要
+func NewFiles(reader *multipart.Reader, upload Upload, digests map[string]string) *Files {
+	if upload.MaxFileBytes <= 0 {
+		upload.MaxFileBytes = defaultMaxFileBytes
+	}
+	if upload.MaxFiles <= 0 {
+		upload.MaxFiles = 1
+	}
+	return &Files{reader: reader, upload: upload, digests: digests}
+}
+
+// MaxBytes 请求中文件的最大总字节数
+func (u Upload) MaxBytes() int64 {
+	max, n := u.MaxFileBytes, int64(u.MaxFiles)
+	if max <= 0 {
+		max = defaultMaxFileBytes
+	}
+	if n <= 0 {
+		n = 1
+	}
+	return max * n
+}
+
+// Next 下一个文件，没有文件时返回 io.EOF。上一个文件没有读完时会被跳过
+func (fs *Files) Next() (*File, error) {
+	if fs.current != nil {
+		_ = fs.current.part.Close()
+		fs.current = nil
+	}
+	for {
+		part, err := fs.reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName() == "" {
+			//文件之间的普通字段忽略
+			_ = part.Close()
+			continue
+		}
+		fs.count++
+		if fs.count > fs.upload.MaxFiles {
+			return nil, ErrUpload.WithMessage(fmt.Sprintf("最多上传%d个文件", fs.upload.MaxFiles))
+		}
+		f := &File{
+			Field: part.FormName(),
+			Name:  part.FileName(),
+			part:  part,
+			max:   fs.upload.MaxFileBytes,
+		}
+		if fs.digests != nil {
+			digest, ok := fs.digests[f.Field]
+			if !ok {
+				return nil, ErrUpload.WithMessage(fmt.Sprintf("%s 缺少文件摘要", f.Field))
+			}
+			f.digest = strings.ToLower(digest)
+			f.hash = sha256.New()
+		}
+		//根据内容判断类型
+		head := make([]byte, sniffLen)
+		n, err := io.ReadFull(part, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, err
+		}
+		head = head[:n]
+		f.ContentType = http.DetectContentType(head)
+		if !allowType(fs.upload.Types, f.ContentType) {
+			return nil, ErrUpload.WithMessage(fmt.Sprintf("%s 不允许上传 %s", f.Field, f.ContentType))
+		}
+		f.reader = io.MultiReader(bytes.NewReader(head), part)
+		fs.current = f
+		return f, nil
+	}
+}
+
+// SaveAll 把所有文件保存到 storage，key 生成每个文件保存的key
+func (fs *Files) SaveAll(ctx context.Context, storage Storage, key func(f *File) string) ([]SavedFile, error) {
+	var saved []SavedFile
+	for {
+		f, err := fs.Next()
+		if err == io.EOF {
+			return saved, nil
+		}
+		if err != nil {
+			return saved, err
+		}
+		k, err := storage.Save(ctx, key(f), f.ContentType, f)
+		if err != nil {
+			return saved, err
+		}
+		saved = append(saved, SavedFile{Field: f.Field, Name: f.Name, ContentType: f.ContentType, Key: k, Size: f.size})
+	}
+}
+
+// allowType 类型是否在允许的列表中
+func allowType(types []string, contentType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	contentType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+	for _, t := range types {
+		if t == contentType || strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.reader.Read(p)
+	f.size += int64(n)
+	if f.hash != nil {
+		f.hash.Write(p[:n])
+	}
+	if f.size > f.max {
+		return n, ErrUpload.WithMessage(fmt.Sprintf("%s 超过%d字节", f.Field, f.max))
+	}
+	if err == io.EOF && f.hash != nil && hex.EncodeToString(f.hash.Sum(nil)) != f.digest {
+		return n, ErrUpload.WithMessage(fmt.Sprintf("%s 文件摘要错误", f.Field))
+	}
+	return n, err
+}
+
+// Size 已经读取的字节数，读完之后为文件大小
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// RegisterUpload 注册上传的路由，Route.Upload 为空时使用默认限制。
+// 请求参数来自 data 字段，与 RegisterTyped 相同，文件通过 files 流式读取或用 files.SaveAll 保存
+func RegisterUpload[Req, Resp any](r Route, handle UploadHandle[Req, Resp]) {
+	if r.Upload == nil {
+		r.Upload = &Upload{}
+	}
+	r.requestType = reflect.TypeOf((*Req)(nil)).Elem()
+	r.responseType = reflect.TypeOf((*Resp)(nil)).Elem()
+	r.contextHandle = func(c *Context) (interface{}, error) {
+		var req Req
+		if err := decode(c, &req); err != nil {
+			return nil, &Error{Code: CodeInvalidParam, Key: "invalid_param", Message: err.Error()}
+		}
+		if c.Files == nil {
+			return nil, ErrUpload.WithMessage("不是上传请求")
+		}
+		return handle(c, req, c.Files)
+	}
+	routes.put(r)
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	. "github.com/qiaojun2016/basic/http/route"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type (
+	// routeHandler 路由处理函数，params 为路径参数
+	routeHandler func(w http.ResponseWriter, r *http.Request, params map[string]string)
+
+	// binding 一个方法注册的路由
+	binding struct {
+		segments []string //注册时的路由，参数名以此为准
+		handler  routeHandler
+	}
+
+	// endpoint 去掉参数名后相同的url，按方法注册的路由
+	endpoint struct {
+		url        string
+		segments   []string
+		parametric bool
+		literals   int                 //非参数段的个数，越多越优先
+		methods    map[string]*binding //指定方法的路由
+		any        *binding            //没有指定方法的路由
+	}
+
+	// router 按 方法+路径 分发请求，支持 /order/{id} 形式的路径参数。
+	// 优先级：完全匹配 > 路径参数（非参数段多的优先）> 以/结尾的前缀匹配（长的优先）
+	router struct {
+		endpoints map[string]*endpoint //key 为去掉参数名的url
+		static    map[string]*endpoint
+		params    []*endpoint
+		subtree   []*endpoint
+		web       bool //跨域时 OPTIONS 交给路由的 Cors 中间件处理
+	}
+)
+
+func newRouter(web bool) *router {
+	return &router{
+		endpoints: make(map[string]*endpoint),
+		static:    make(map[string]*endpoint),
+		web:       web,
+	}
+}
+
+// handle 注册路由，method 为空时接收所有方法
+func (rt *router) handle(method, url string, handler routeHandler) {
+	segments := Segments(url)
+	shape := Shape(url)
+	e, ok := rt.endpoints[shape]
+	if !ok {
+		e = &endpoint{
+			url:      url,
+			segments: segments,
+			methods:  make(map[string]*binding),
+		}
+		for _, segment := range segments {
+			if _, isParam := IsParam(segment); isParam {
+				e.parametric = true
+			} else {
+				e.literals++
+			}
+		}
+		rt.endpoints[shape] = e
+		if e.parametric {
+			rt.params = append(rt.params, e)
+			sort.SliceStable(rt.params, func(i, j int) bool {
+				return rt.params[i].literals > rt.params[j].literals
+			})
+		} else {
+			rt.static[url] = e
+			if strings.HasSuffix(url, "/") {
+				rt.subtree = append(rt.subtree, e)
+				sort.SliceStable(rt.subtree, func(i, j int) bool {
+					return len(rt.subtree[i].url) > len(rt.subtree[j].url)
+				})
+			}
+		}
+	}
+	b := &binding{
+		segments: segments,
+		handler:  handler,
+	}
+	if method == "" {
+		e.any = b
+	} else {
+		e.methods[method] = b
+	}
+}
+
+// match 按去掉参数名后的url匹配
+func (e *endpoint) match(segments []string) bool {
+	if len(segments) != len(e.segments) {
+		return false
+	}
+	for i, segment := range e.segments {
+		if _, ok := IsParam(segment); ok {
+			if segments[i] == "" {
+				return false
+			}
+		} else if segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// params 按注册时的参数名提取路径参数
+func (b *binding) params(segments []string) map[string]string {
+	params := make(map[string]string)
+	for i, segment := range b.segments {
+		if name, ok := IsParam(segment); ok {
+			params[name] = segments[i]
+		}
+	}
+	return params
+}
+
+func (rt *router) find(path string) (*endpoint, []string) {
+	if e, ok := rt.static[path]; ok {
+		return e, nil
+	}
+	segments := Segments(path)
+	for _, e := range rt.params {
+		if e.match(segments) {
+			return e, segments
+		}
+	}
+	for _, e := range rt.subtree {
+		if strings.HasPrefix(path, e.url) {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e, segments := rt.find(r.URL.Path)
+	if e == nil {
+		http.NotFound(w, r)
+		return
+	}
+	b, ok := e.methods[r.Method]
+	if !ok {
+		b = e.any
+	}
+	if b == nil && rt.web && r.Method == http.MethodOptions {
+		//预检请求交给任意一个路由的Cors中间件
+		for _, m := range e.methods {
+			b = m
+			break
+		}
+	}
+	if b == nil {
+		allow := make([]string, 0, len(e.methods))
+		for method := range e.methods {
+			allow = append(allow, method)
+		}
+		sort.Strings(allow)
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		http.Error(w, r.Method+" "+r.URL.Path+" : method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var params map[string]string
+	if e.parametric {
+		params = b.params(segments)
+	}
+	b.handler(w, r, params)
+}
@@ -0,0 +1,48 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_router(t *testing.T) {
+	rt := newRouter(false)
+	var got string
+	var gotParams map[string]string
+	h := func(name string) routeHandler {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			got, gotParams = name, params
+		}
+	}
+	rt.handle(http.MethodGet, "/order/{id}", h("get order"))
+	rt.handle(http.MethodPost, "/order/{no}", h("post order"))
+	rt.handle(http.MethodGet, "/order/list", h("order list"))
+	rt.handle("", "/static/", h("static"))
+
+	cases := []struct {
+		method, path, name, param string
+		code                      int
+	}{
+		{http.MethodGet, "/order/12", "get order", "12", http.StatusOK},
+		{http.MethodPost, "/order/12", "post order", "12", http.StatusOK},
+		{http.MethodGet, "/order/list", "order list", "", http.StatusOK},
+		{http.MethodPut, "/static/a/b.png", "static", "", http.StatusOK},
+		{http.MethodDelete, "/order/12", "", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/user/12", "", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		got, gotParams = "", nil
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		if w.Code != c.code || got != c.name {
+			t.Fatalf("%s %s: code %d handler %q", c.method, c.path, w.Code, got)
+		}
+		if c.param != "" && gotParams["id"] != c.param && gotParams["no"] != c.param {
+			t.Fatalf("%s %s: params %v", c.method, c.path, gotParams)
+		}
+		if c.code == http.StatusMethodNotAllowed && w.Header().Get("Allow") != "GET, POST" {
+			t.Fatalf("Allow: %s", w.Header().Get("Allow"))
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"context"
+	. "github.com/qiaojun2016/basic/http/route"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_Shutdown(t *testing.T) {
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	entered := make(chan struct{})
+	RegisterTyped(Route{
+		Method:  http.MethodPost,
+		Url:     "/test/service/slow",
+		Pattern: Pattern{Auth: AuthDisable},
+	}, func(c *Context, req map[string]interface{}) (string, error) {
+		close(entered)
+		time.Sleep(300 * time.Millisecond)
+		return "done", nil
+	})
+	//空闲的端口
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	s := NewService(Server{Addr: addr})
+	ran := make(chan error, 1)
+	go func() {
+		ran <- s.Run()
+	}()
+
+	//处理中的请求在关闭时完成
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		for i := 0; ; i++ {
+			resp, err := http.Post("http://"+addr+"/test/service/slow", "application/json", strings.NewReader(`{}`))
+			if err != nil && i < 50 {
+				//服务还没有开始监听
+				time.Sleep(20 * time.Millisecond)
+				continue
+			}
+			if err != nil {
+				done <- result{err: err}
+				return
+			}
+			b, _ := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			done <- result{status: resp.StatusCode, body: string(b)}
+			return
+		}
+	}()
+	select {
+	case <-entered:
+	case <-time.After(3 * time.Second):
+		t.Fatal("request not started")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r := <-done
+	if r.err != nil || r.status != http.StatusOK || !strings.Contains(r.body, "done") {
+		t.Fatal("in-flight request", r)
+	}
+	select {
+	case err = <-ran:
+		if err != nil {
+			t.Fatal("run", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("run did not return")
+	}
+	//后台协程已停止
+	select {
+	case <-s.done:
+	default:
+		t.Fatal("done not closed")
+	}
+	//关闭之后 Run 直接返回
+	if err = s.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestService_ShutdownBeforeRun(t *testing.T) {
+	s := NewService(Server{Addr: "127.0.0.1:0"})
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestService_configPanic(t *testing.T) {
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	//有角色要求但没有 RoleResolver，mux panic
+	RegisterTyped(Route{Method: http.MethodPost, Url: "/test/service/roles", Pattern: Pattern{Roles: []string{"admin"}}},
+		func(c *Context, req map[string]interface{}) (interface{}, error) {
+			return nil, nil
+		})
+	s := NewService(Server{Addr: "127.0.0.1:0"})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected panic")
+			}
+		}()
+		_ = s.Run()
+	}()
+	//panic 之后没有持有锁
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown blocked after a config panic")
+	}
+}
+
+func TestLocalLimiter_stop(t *testing.T) {
+	done := make(chan struct{})
+	l := NewLocalLimiter(done)
+	close(done)
+	select {
+	case <-l.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("dump goroutine did not exit")
+	}
+}
@@ -0,0 +1,140 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/qiaojun2016/basic/http/client"
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/id"
+	"github.com/qiaojun2016/basic/token"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegisterStream(t *testing.T) {
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	id.Server{Node: 1}.Run()
+	type req struct {
+		Count int `json:"count"`
+	}
+	handle := func(c *Context, req req, s *StreamWriter) error {
+		if req.Count == 0 {
+			return NewError(CodeInvalidParam, "invalid_param", "count")
+		}
+		for i := 1; i <= req.Count; i++ {
+			if err := s.Send("progress", map[string]int{"done": i}); err != nil {
+				return err
+			}
+		}
+		if req.Count > 2 {
+			return errors.New("export failed")
+		}
+		return nil
+	}
+	RegisterStream(Route{Method: http.MethodPost, Url: "/test/stream/sse"}, handle)
+	RegisterStream(Route{Method: http.MethodPost, Url: "/test/stream/ndjson", Stream: &Stream{Format: StreamNDJSON}}, handle)
+	RegisterStream(Route{
+		Method:  http.MethodPost,
+		Url:     "/test/stream/wait",
+		Pattern: Pattern{Auth: AuthDisable},
+		Stream:  &Stream{MaxDuration: 50 * time.Millisecond, Heartbeat: 10 * time.Millisecond},
+	}, func(c *Context, req req, s *StreamWriter) error {
+		<-c.Context().Done()
+		return c.Context().Err()
+	})
+	srv := httptest.NewServer(Server{MaxPayloadBytes: 1 << 20}.mux(nil))
+	defer srv.Close()
+
+	tk := token.Token{Id: 1}
+	c := client.New(srv.URL)
+	if err := c.SetToken(tk.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, path := range []string{"/test/stream/sse", "/test/stream/ndjson"} {
+		var done []int
+		err := c.Stream(ctx, client.Request{Path: path, Param: req{Count: 2}}, func(event string, data json.RawMessage) error {
+			var p map[string]int
+			if err := json.Unmarshal(data, &p); err != nil || event != "progress" {
+				t.Fatal(event, string(data))
+			}
+			done = append(done, p["done"])
+			return nil
+		})
+		if err != nil || len(done) != 2 || done[1] != 2 {
+			t.Fatal(path, done, err)
+		}
+
+		//开始之前出错为普通返回，之后出错为 error 事件
+		err = c.Stream(ctx, client.Request{Path: path, Param: req{}}, func(string, json.RawMessage) error { return nil })
+		if stateErr, ok := err.(*client.StateError); !ok || stateErr.Code != CodeInvalidParam {
+			t.Fatal(path, err)
+		}
+		err = c.Stream(ctx, client.Request{Path: path, Param: req{Count: 3}}, func(string, json.RawMessage) error { return nil })
+		if stateErr, ok := err.(*client.StateError); !ok || stateErr.Code != CodeUnknown {
+			t.Fatal(path, err)
+		}
+	}
+
+	//心跳之后超时结束
+	start := time.Now()
+	if err := c.Stream(ctx, client.Request{Path: "/test/stream/wait"}, func(string, json.RawMessage) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("stream not closed after MaxDuration")
+	}
+}
+
+func TestRegisterStream_writeTimeout(t *testing.T) {
+	if !streamDeadline {
+		t.Skip("requires go1.20")
+	}
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	RegisterStream(Route{Method: http.MethodPost, Url: "/test/stream/slow", Pattern: Pattern{Auth: AuthDisable}},
+		func(c *Context, req map[string]interface{}, s *StreamWriter) error {
+			for i := 1; i <= 2; i++ {
+				if err := s.Send("progress", i); err != nil {
+					return err
+				}
+				time.Sleep(700 * time.Millisecond)
+			}
+			return nil
+		})
+	RegisterTyped(Route{Method: http.MethodPost, Url: "/test/stream/plain", Pattern: Pattern{Auth: AuthDisable}},
+		func(c *Context, req map[string]interface{}) (string, error) {
+			time.Sleep(1400 * time.Millisecond)
+			return "late", nil
+		})
+	//与 Run 相同，http.Server 设置写超时
+	srv := httptest.NewUnstartedServer(Server{MaxPayloadBytes: 1 << 20, WriteTimeout: 1}.mux(nil))
+	srv.Config.WriteTimeout = time.Second
+	srv.Start()
+	defer srv.Close()
+	post := func(url string) (string, error) {
+		resp, err := http.Post(srv.URL+url, "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	//流式输出超过 WriteTimeout 仍然完整
+	body, err := post("/test/stream/slow")
+	if err != nil || strings.Count(body, "event: progress") != 2 {
+		t.Fatal(err, body)
+	}
+	//其他路由仍然受 WriteTimeout 限制
+	if body, err = post("/test/stream/plain"); err == nil && strings.Contains(body, "late") {
+		t.Fatal("write timeout not applied", body)
+	}
+}
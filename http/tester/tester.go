@@ -0,0 +1,170 @@
+// Package tester 路由的测试工具，不监听端口、不依赖redis，请求经过与 http.Server.Run 相同的中间件
+package tester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	basic "github.com/qiaojun2016/basic/http"
+	"github.com/qiaojun2016/basic/http/client"
+	"github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/id"
+	"github.com/qiaojun2016/basic/token"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//用法
+/*
+func TestOrder(t *testing.T) {
+	tester.Isolate(t)
+	route.RegisterTyped(route.Route{Method: "POST", Url: "/order", Pattern: route.Pattern{Cache: route.Enable}}, createOrder)
+	h := tester.New(t, http.Server{})
+	h.Login(1001)
+	var order Order
+	h.Expect(client.Request{Path: "/order", Param: param}, &order)
+	h.ExpectError(client.Request{Path: "/order"}, route.CodeInvalidParam)
+}
+测试结束时恢复路由表，init 中注册的路由不受影响，缓存的存储只属于这个 Harness
+没有运行 token.Server 时使用固定的测试密钥
+*/
+
+const (
+	defaultRemoteAddr = "127.0.0.1:10000"
+	testSecret        = "basic-tester-secret" //只用于测试
+)
+
+type (
+	// Harness 一个测试的服务和客户端
+	Harness struct {
+		Client     *client.Client          //已设置 BaseURL 和传输，Login 之后携带令牌并签名
+		Server     basic.Server            //生成 Handler 的配置，清除缓存使用 Server.InvalidateRoute
+		Cache      *basic.MemoryCacheStore //Server.CacheStore 为空时使用的进程内缓存
+		RemoteAddr string                  //请求的来源地址，默认 127.0.0.1:10000
+		Handler    http.Handler
+
+		tb testing.TB
+	}
+
+	// transport 直接调用 Handler，不经过网络
+	transport struct {
+		h *Harness
+	}
+)
+
+func (t transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	req := r.Clone(r.Context())
+	req.RemoteAddr = t.h.RemoteAddr
+	req.RequestURI = r.URL.RequestURI()
+	w := httptest.NewRecorder()
+	t.h.Handler.ServeHTTP(w, req)
+	return w.Result(), nil
+}
+
+// Isolate 保存当前的路由表，测试结束时恢复，在注册测试的路由之前调用。
+// init 中注册的路由保留，测试注册的路由不会影响之后的测试
+func Isolate(tb testing.TB) {
+	snapshot := route.Snapshot()
+	tb.Cleanup(func() {
+		route.Restore(snapshot)
+	})
+}
+
+// New 按当前的路由表生成服务，server.CacheStore 为空时使用进程内的缓存。
+// 测试结束时停止后台协程
+func New(tb testing.TB, server basic.Server) *Harness {
+	tb.Helper()
+	//令牌的session
+	if id.SId == nil {
+		id.Server{Node: 1}.Run()
+	}
+	//没有运行 token.Server 时使用固定的测试密钥
+	if !token.HasSecret() {
+		token.Server{Secret: []byte(testSecret)}.Run()
+	}
+	h := &Harness{RemoteAddr: defaultRemoteAddr, tb: tb}
+	if server.CacheStore == nil {
+		h.Cache = basic.NewMemoryCacheStore()
+		server.CacheStore = h.Cache
+	}
+	h.Server = server
+	done := make(chan struct{})
+	h.Handler = server.Handler(done)
+	h.Client = client.New("http://tester")
+	h.Client.HTTPClient = &http.Client{Transport: transport{h: h}}
+	tb.Cleanup(func() {
+		close(done)
+	})
+	return h
+}
+
+// Login 用 token.Token.Encode 签发用户 userId 的令牌，之后的请求携带令牌并签名，返回令牌
+func (h *Harness) Login(userId int64) string {
+	h.tb.Helper()
+	tk := token.Token{Id: userId}
+	t := tk.Encode()
+	if err := h.Client.SetToken(t); err != nil {
+		h.tb.Fatalf("set token: %s", err)
+	}
+	return t
+}
+
+// Do 发送请求，返回解析后的 {version,state,data,error}
+func (h *Harness) Do(req client.Request) (*client.Response, error) {
+	return h.Client.Do(context.Background(), req)
+}
+
+// Expect 断言请求成功（200 且 state 为 OK），data 解析到 result，result 为nil时不解析
+func (h *Harness) Expect(req client.Request, result interface{}) {
+	h.tb.Helper()
+	resp, err := h.Do(req)
+	if err != nil {
+		h.tb.Fatalf("%s %s: %s", method(req), req.Path, err)
+	}
+	if result == nil {
+		return
+	}
+	if err = json.Unmarshal(resp.Data, result); err != nil {
+		h.tb.Fatalf("%s %s: decode data: %s", method(req), req.Path, err)
+	}
+}
+
+// ExpectError 断言请求失败且错误码为 code，包括中间件中断（非200）和 handle 返回的错误，返回该错误
+func (h *Harness) ExpectError(req client.Request, code int) error {
+	h.tb.Helper()
+	_, err := h.Do(req)
+	if err == nil {
+		h.tb.Fatalf("%s %s: expect error %d, got OK", method(req), req.Path, code)
+	}
+	got := 0
+	var statusErr *client.StatusError
+	var stateErr *client.StateError
+	if errors.As(err, &statusErr) {
+		got = statusErr.Code
+	} else if errors.As(err, &stateErr) {
+		got = stateErr.Code
+	}
+	if got != code {
+		h.tb.Fatalf("%s %s: expect error %d, got %d: %s", method(req), req.Path, code, got, err)
+	}
+	return err
+}
+
+// Serve 直接发送一个请求，不签名，返回原始的响应，用于测试中间件的拒绝
+func (h *Harness) Serve(r *http.Request) *httptest.ResponseRecorder {
+	//httptest.NewRequest 默认的地址为 192.0.2.1:1234
+	if r.RemoteAddr == "" || r.RemoteAddr == "192.0.2.1:1234" {
+		r.RemoteAddr = h.RemoteAddr
+	}
+	w := httptest.NewRecorder()
+	h.Handler.ServeHTTP(w, r)
+	return w
+}
+
+func method(req client.Request) string {
+	if req.Method == "" {
+		return http.MethodPost
+	}
+	return req.Method
+}
@@ -0,0 +1,76 @@
+package tester
+
+import (
+	"fmt"
+	"github.com/qiaojun2016/basic/http"
+	"github.com/qiaojun2016/basic/http/client"
+	"github.com/qiaojun2016/basic/http/route"
+	"net/http/httptest"
+	"testing"
+)
+
+type (
+	greetReq struct {
+		Name string `json:"name" required:"true"`
+	}
+	greetResp struct {
+		Message string `json:"message"`
+		UserId  int64  `json:"userId"`
+	}
+)
+
+// init 中注册的路由，如业务包的路由
+func init() {
+	route.RegisterTyped(route.Route{Method: "POST", Url: "/test/init", Pattern: route.Pattern{Auth: route.AuthDisable}},
+		func(c *route.Context, req map[string]interface{}) (string, error) {
+			return "init", nil
+		})
+}
+
+func TestHarness(t *testing.T) {
+	Isolate(t)
+	calls := 0
+	route.RegisterTyped(route.Route{
+		Method:  "POST",
+		Url:     "/test/greet",
+		Pattern: route.Pattern{Auth: route.Enable, Cache: route.Enable},
+	}, func(c *route.Context, req greetReq) (greetResp, error) {
+		calls++
+		return greetResp{Message: fmt.Sprintf("hello %s", req.Name), UserId: c.Id}, nil
+	})
+	h := New(t, http.Server{})
+	req := client.Request{Path: "/test/greet", Param: greetReq{Name: "basic"}}
+	h.ExpectError(req, route.CodeTokenMissing)
+
+	h.Login(1001)
+	var resp greetResp
+	h.Expect(req, &resp)
+	h.Expect(req, &resp)
+	if resp.Message != "hello basic" || resp.UserId != 1001 {
+		t.Fatal(resp)
+	}
+	//第二次命中进程内的缓存
+	if calls != 1 || h.Cache.Len() != 1 {
+		t.Fatal(calls, h.Cache.Len())
+	}
+	if err := h.Server.InvalidateRoute("POST /test/greet"); err != nil || h.Cache.Len() != 0 {
+		t.Fatal(err, h.Cache.Len())
+	}
+	h.ExpectError(client.Request{Path: "/test/greet", Param: greetReq{}}, route.CodeInvalidParam)
+}
+
+func TestHarness_reset(t *testing.T) {
+	//上一个测试注册的路由已删除，init 中注册的路由保留
+	if _, ok := route.All()["POST /test/greet"]; ok {
+		t.Fatal(route.All())
+	}
+	h := New(t, http.Server{})
+	if w := h.Serve(httptest.NewRequest("POST", "/test/greet", nil)); w.Code != 404 {
+		t.Fatal(w.Code)
+	}
+	var s string
+	h.Expect(client.Request{Path: "/test/init"}, &s)
+	if s != "init" {
+		t.Fatal(s)
+	}
+}
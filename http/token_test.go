@@ -0,0 +1,82 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/qiaojun2016/basic/http/client"
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/id"
+	"github.com/qiaojun2016/basic/token"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func init() {
+	//测试签发的令牌使用固定的密钥
+	token.Server{Secret: []byte("http-test-secret-0123456789")}.Run()
+}
+
+func TestAuth_tokens(t *testing.T) {
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	id.Server{Node: 1}.Run()
+	RegisterTyped(Route{
+		Method:  http.MethodPost,
+		Url:     "/test/token",
+		Pattern: Pattern{Auth: Enable},
+	}, func(c *Context, req map[string]interface{}) (int64, error) {
+		return c.Id, nil
+	})
+	tokens := &token.Manager{TTL: time.Hour, Store: token.NewLocalRevokeStore()}
+	srv := httptest.NewServer(Server{MaxPayloadBytes: 1 << 20, Tokens: tokens}.mux(nil))
+	defer srv.Close()
+
+	code := func(t string) int {
+		return tokenCode(srv.URL, t)
+	}
+	pair := tokens.Issue(9)
+	if got := code(pair.Token); got != CodeOK {
+		t.Fatal(got)
+	}
+	//刷新令牌不能访问接口
+	if got := code(pair.RefreshToken); got != CodeTokenInvalid {
+		t.Fatal(got)
+	}
+	if err := tokens.Revoke(pair.Token); err != nil {
+		t.Fatal(err)
+	}
+	if got := code(pair.Token); got != CodeTokenRevoked {
+		t.Fatal(got)
+	}
+	//过期
+	expired := httptest.NewServer(Server{MaxPayloadBytes: 1 << 20, Tokens: &token.Manager{TTL: time.Nanosecond}}.mux(nil))
+	defer expired.Close()
+	if got := tokenCode(expired.URL, tokens.Issue(9).Token); got != CodeTokenExpired {
+		t.Fatal(got)
+	}
+	//没有吊销存储时拒绝，不跳过检查
+	noStore := httptest.NewServer(Server{MaxPayloadBytes: 1 << 20, Tokens: &token.Manager{TTL: time.Hour}}.mux(nil))
+	defer noStore.Close()
+	if got := tokenCode(noStore.URL, tokens.Issue(9).Token); got != CodeInternal {
+		t.Fatal(got)
+	}
+}
+
+// tokenCode 使用令牌 t 请求 /test/token，返回中断请求的错误码
+func tokenCode(url, t string) int {
+	c := client.New(url)
+	if err := c.SetToken(t); err != nil {
+		return -1
+	}
+	_, err := c.Do(context.Background(), client.Request{Path: "/test/token"})
+	var statusErr *client.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code
+	}
+	if err != nil {
+		return -1
+	}
+	return CodeOK
+}
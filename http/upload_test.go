@@ -0,0 +1,81 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"github.com/qiaojun2016/basic/http/client"
+	. "github.com/qiaojun2016/basic/http/route"
+	"github.com/qiaojun2016/basic/id"
+	"github.com/qiaojun2016/basic/token"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// memoryStorage 保存到内存
+type memoryStorage map[string][]byte
+
+func (s memoryStorage) Save(ctx context.Context, key, contentType string, r io.Reader) (string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	s[key] = b
+	return "mem://" + key, nil
+}
+
+func TestRegisterUpload(t *testing.T) {
+	id.Server{Node: 1}.Run()
+	storage := memoryStorage{}
+	type req struct {
+		Album string `json:"album"`
+	}
+	RegisterUpload(Route{
+		Method: http.MethodPost,
+		Url:    "/test/upload",
+		Upload: &Upload{MaxFileBytes: 1 << 10, MaxFiles: 2, Types: []string{"text/*"}},
+	}, func(c *Context, req req, files *Files) ([]SavedFile, error) {
+		return files.SaveAll(c.Request.Context(), storage, func(f *File) string {
+			return req.Album + "/" + f.Name
+		})
+	})
+	srv := httptest.NewServer(Server{MaxPayloadBytes: 1 << 20}.mux(nil))
+	defer srv.Close()
+
+	tk := token.Token{Id: 1}
+	c := client.New(srv.URL)
+	if err := c.SetToken(tk.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	upload := func(files ...client.File) ([]SavedFile, error) {
+		return client.Call[[]SavedFile](context.Background(), c, client.Request{
+			Path:  "/test/upload",
+			Param: map[string]string{"album": "a"},
+			Files: files,
+		})
+	}
+
+	saved, err := upload(
+		client.File{Field: "f1", Name: "1.txt", Body: bytes.NewReader([]byte("hello"))},
+		client.File{Field: "f2", Name: "2.txt", Body: bytes.NewReader([]byte("world"))},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 2 || saved[0].Key != "mem://a/1.txt" || saved[1].Size != 5 || string(storage["a/2.txt"]) != "world" {
+		t.Fatalf("%+v", saved)
+	}
+
+	for name, files := range map[string][]client.File{
+		"type":  {{Field: "f1", Name: "1.png", Body: bytes.NewReader([]byte("\x89PNG\r\n\x1a\n0000"))}},
+		"size":  {{Field: "f1", Name: "1.txt", Body: bytes.NewReader(bytes.Repeat([]byte("a"), 2<<10))}},
+		"count": {{Field: "f1", Name: "1.txt", Body: bytes.NewReader([]byte("1"))}, {Field: "f2", Name: "2.txt", Body: bytes.NewReader([]byte("2"))}, {Field: "f3", Name: "3.txt", Body: bytes.NewReader([]byte("3"))}},
+	} {
+		_, err = upload(files...)
+		if stateErr, ok := err.(*client.StateError); !ok || stateErr.Code != CodeInvalidFile {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/qiaojun2016/basic/http/route"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVersions(t *testing.T) {
+	snapshot := Snapshot()
+	defer Restore(snapshot)
+	deprecation := time.Now().Add(-time.Hour)
+	sunset := time.Now().Add(time.Hour)
+	register := func(p Pattern, name string) {
+		p.Auth = AuthDisable
+		RegisterTyped(Route{Method: http.MethodPost, Url: "/test/version/{id}", Pattern: p},
+			func(c *Context, req map[string]interface{}) (string, error) {
+				return name + c.PathValue("id"), nil
+			})
+	}
+	register(Pattern{Version: 1, MaxVersion: 2, Deprecation: deprecation, Sunset: sunset}, "v1:")
+	register(Pattern{Version: 3}, "v3:")
+	RegisterTyped(Route{Method: http.MethodPost, Url: "/test/version/sunset", Pattern: Pattern{Auth: AuthDisable, Sunset: time.Now().Add(-time.Hour)}},
+		func(c *Context, req map[string]interface{}) (string, error) {
+			return "", nil
+		})
+	mux := Server{MaxPayloadBytes: 1 << 20}.mux(nil)
+
+	post := func(url string, v int) (*httptest.ResponseRecorder, response) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, url, strings.NewReader(fmt.Sprintf(`{"v":%d}`, v)))
+		mux.ServeHTTP(w, r)
+		var body response
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+	w, body := post("/test/version/7", 2)
+	if w.Code != http.StatusOK || body.Data != "v1:7" || w.Header().Get("Sunset") == "" || !strings.HasPrefix(w.Header().Get("Deprecation"), "@") {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+	w, body = post("/test/version/7", 5)
+	if w.Code != http.StatusOK || body.Data != "v3:7" || w.Header().Get("Deprecation") != "" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w, _ = post("/test/version/7", 0); w.Code != http.StatusGone {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if w, _ = post("/test/version/sunset", 1); w.Code != http.StatusGone || w.Header().Get("Sunset") == "" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("overlapping versions registered")
+		}
+	}()
+	register(Pattern{Version: 2, MaxVersion: 3}, "overlap:")
+}
@@ -0,0 +1,84 @@
+package ip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+type (
+	// Networks 网段列表
+	Networks []*net.IPNet
+
+	// Access ip的允许和拒绝列表，拒绝优先，允许列表不为空时只允许其中的ip
+	Access struct {
+		allow Networks
+		deny  Networks
+	}
+)
+
+// ParseNetworks 解析网段或ip，如 10.0.0.0/8、192.168.1.10、::1
+func ParseNetworks(cidrs ...string) (Networks, error) {
+	var networks Networks
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Contains ip 是否在列表中
+func (n Networks) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAccess 创建允许和拒绝列表，两个列表都为空时返回nil，nil 允许所有ip
+func NewAccess(allow, deny []string) (*Access, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	a := &Access{}
+	var err error
+	if a.allow, err = ParseNetworks(allow...); err != nil {
+		return nil, err
+	}
+	if a.deny, err = ParseNetworks(deny...); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Allowed ip 是否允许访问，不是ip时只有两个列表都为空才允许
+func (a *Access) Allowed(ip string) bool {
+	if a == nil {
+		return true
+	}
+	parsed := ParseHost(ip)
+	if parsed == nil || a.deny.Contains(parsed) {
+		return false
+	}
+	return len(a.allow) == 0 || a.allow.Contains(parsed)
+}
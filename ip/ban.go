@@ -0,0 +1,314 @@
+package ip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/qiaojun2016/basic/redis"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+//封禁，窗口内违规（限流、签名错误等）次数达到阈值的ip在一段时间内拒绝访问
+/*
+管理接口，挂在管理端口上，如 metrics.Handle("/bans", bans.Handler())
+GET    /bans          封禁列表
+DELETE /bans?ip=x.x.x.x 解封
+*/
+
+const (
+	banPrefix    = "basic:ban:"
+	strikePrefix = "basic:strike:"
+
+	defaultBanThreshold = 10
+	defaultBanWindow    = time.Minute
+	defaultBanDuration  = 10 * time.Minute
+	maxLocalStrikes     = 10000 //进程内记录数超过后清理过期的记录
+)
+
+type (
+	// BanEntry 一条封禁记录
+	BanEntry struct {
+		Ip     string    `json:"ip"`
+		Reason string    `json:"reason"`
+		Until  time.Time `json:"until"`
+	}
+
+	// BanStore 保存违规次数和封禁记录
+	BanStore interface {
+		// Strike 记录一次违规，返回 window 内的次数
+		Strike(ip string, window time.Duration) (int64, error)
+		// Ban 封禁到 entry.Until
+		Ban(entry BanEntry) error
+		// Banned ip 是否在封禁中
+		Banned(ip string) (bool, error)
+		// Unban 解封并清除违规次数
+		Unban(ip string) error
+		// List 封禁中的记录
+		List() ([]BanEntry, error)
+	}
+
+	// RedisBanStore 使用redis保存，多个实例共享
+	RedisBanStore struct{}
+
+	// LocalBanStore 保存在进程内
+	LocalBanStore struct {
+		mu      sync.Mutex
+		strikes map[string]strike
+		bans    map[string]BanEntry
+	}
+
+	strike struct {
+		count int64
+		reset time.Time
+	}
+
+	// BanList 自动封禁，http 和 ws 可以共用一个
+	BanList struct {
+		Threshold int           //窗口内违规次数达到后封禁，默认10
+		Window    time.Duration //统计违规次数的窗口，默认1分钟
+		Duration  time.Duration //封禁时长，默认10分钟
+		Store     BanStore      //为空时已启动redis使用 RedisBanStore，否则使用进程内的存储
+		local     *LocalBanStore
+		once      sync.Once
+	}
+)
+
+// NewLocalBanStore 创建进程内的存储
+func NewLocalBanStore() *LocalBanStore {
+	return &LocalBanStore{strikes: map[string]strike{}, bans: map[string]BanEntry{}}
+}
+
+func (s *LocalBanStore) Strike(ip string, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if len(s.strikes) > maxLocalStrikes {
+		for k, v := range s.strikes {
+			if now.After(v.reset) {
+				delete(s.strikes, k)
+			}
+		}
+	}
+	st := s.strikes[ip]
+	if now.After(st.reset) {
+		st = strike{reset: now.Add(window)}
+	}
+	st.count++
+	s.strikes[ip] = st
+	return st.count, nil
+}
+
+func (s *LocalBanStore) Ban(entry BanEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bans[entry.Ip] = entry
+	return nil
+}
+
+func (s *LocalBanStore) Banned(ip string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.bans[ip]
+	if ok && time.Now().After(entry.Until) {
+		delete(s.bans, ip)
+		return false, nil
+	}
+	return ok, nil
+}
+
+func (s *LocalBanStore) Unban(ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.bans, ip)
+	delete(s.strikes, ip)
+	return nil
+}
+
+func (s *LocalBanStore) List() ([]BanEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	entries := make([]BanEntry, 0, len(s.bans))
+	for ip, entry := range s.bans {
+		if now.After(entry.Until) {
+			delete(s.bans, ip)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// strikeScript 计数并在第一次违规时设置窗口，原子执行，没有过期时间的key（之前的版本中断留下的）同样设置
+var strikeScript = goredis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 or redis.call('PTTL', KEYS[1]) == -1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return n
+`)
+
+func (RedisBanStore) Strike(ip string, window time.Duration) (int64, error) {
+	if redis.Redis == nil {
+		return 0, fmt.Errorf("redis not run")
+	}
+	return strikeScript.Run(context.Background(), redis.Redis.Client(), []string{strikePrefix + ip}, window.Milliseconds()).Int64()
+}
+
+func (RedisBanStore) Ban(entry BanEntry) error {
+	if redis.Redis == nil {
+		return fmt.Errorf("redis not run")
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return redis.Redis.Client().Set(context.Background(), banPrefix+entry.Ip, b, time.Until(entry.Until)).Err()
+}
+
+func (RedisBanStore) Banned(ip string) (bool, error) {
+	if redis.Redis == nil {
+		return false, fmt.Errorf("redis not run")
+	}
+	n, err := redis.Redis.Client().Exists(context.Background(), banPrefix+ip).Result()
+	return n > 0, err
+}
+
+func (RedisBanStore) Unban(ip string) error {
+	if redis.Redis == nil {
+		return fmt.Errorf("redis not run")
+	}
+	return redis.Redis.Client().Del(context.Background(), banPrefix+ip, strikePrefix+ip).Err()
+}
+
+func (RedisBanStore) List() ([]BanEntry, error) {
+	if redis.Redis == nil {
+		return nil, fmt.Errorf("redis not run")
+	}
+	ctx := context.Background()
+	client := redis.Redis.Client()
+	var entries []BanEntry
+	iter := client.Scan(ctx, 0, banPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		b, err := client.Get(ctx, iter.Val()).Bytes()
+		if err != nil {
+			//已过期
+			continue
+		}
+		var entry BanEntry
+		if err = json.Unmarshal(b, &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, iter.Err()
+}
+
+// store 配置的存储，没有配置时选择redis或进程内
+func (b *BanList) store() BanStore {
+	if b.Store != nil {
+		return b.Store
+	}
+	if redis.Redis != nil {
+		return RedisBanStore{}
+	}
+	b.once.Do(func() {
+		b.local = NewLocalBanStore()
+	})
+	return b.local
+}
+
+// Strike 记录一次违规，达到阈值时封禁，返回是否封禁。b 为nil时不处理
+func (b *BanList) Strike(ip, reason string) bool {
+	if b == nil || ip == "" {
+		return false
+	}
+	threshold, window, duration := b.Threshold, b.Window, b.Duration
+	if threshold <= 0 {
+		threshold = defaultBanThreshold
+	}
+	if window <= 0 {
+		window = defaultBanWindow
+	}
+	if duration <= 0 {
+		duration = defaultBanDuration
+	}
+	store := b.store()
+	n, err := store.Strike(ip, window)
+	if err != nil {
+		log.Println("[ban]", err)
+		return false
+	}
+	if n < int64(threshold) {
+		return false
+	}
+	if err = store.Ban(BanEntry{Ip: ip, Reason: reason, Until: time.Now().Add(duration)}); err != nil {
+		log.Println("[ban]", err)
+		return false
+	}
+	log.Printf("[ban] %s banned for %s: %s\n", ip, duration, reason)
+	return true
+}
+
+// Banned ip 是否在封禁中，存储出错时不拦截。b 为nil时返回false
+func (b *BanList) Banned(ip string) bool {
+	if b == nil || ip == "" {
+		return false
+	}
+	banned, err := b.store().Banned(ip)
+	if err != nil {
+		log.Println("[ban]", err)
+		return false
+	}
+	return banned
+}
+
+// Unban 解封
+func (b *BanList) Unban(ip string) error {
+	return b.store().Unban(ip)
+}
+
+// List 封禁中的记录，按解封时间排序
+func (b *BanList) List() ([]BanEntry, error) {
+	entries, err := b.store().List()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Until.Before(entries[j].Until)
+	})
+	return entries, err
+}
+
+// Handler 管理接口，GET 返回封禁列表，DELETE ?ip= 解封。只应挂在不对外开放的管理端口上
+func (b *BanList) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			entries, err := b.List()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if entries == nil {
+				entries = []BanEntry{}
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			_ = json.NewEncoder(w).Encode(entries)
+		case http.MethodDelete:
+			ip := r.URL.Query().Get("ip")
+			if ip == "" {
+				http.Error(w, "ip is empty", http.StatusBadRequest)
+				return
+			}
+			if err := b.Unban(ip); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package ip
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	b := &BanList{Threshold: 3, Window: time.Minute, Duration: time.Minute, Store: NewLocalBanStore()}
+	for i := 0; i < 2; i++ {
+		if b.Strike("1.2.3.4", "test") {
+			t.Fatal("banned before threshold")
+		}
+	}
+	if !b.Strike("1.2.3.4", "test") || !b.Banned("1.2.3.4") || b.Banned("1.2.3.5") {
+		t.Fatal("not banned")
+	}
+
+	h := b.Handler()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/bans", nil))
+	var entries []BanEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil || len(entries) != 1 || entries[0].Reason != "test" {
+		t.Fatal(w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/bans?ip=1.2.3.4", nil))
+	if w.Code != http.StatusNoContent || b.Banned("1.2.3.4") {
+		t.Fatal(w.Code)
+	}
+
+	var nilList *BanList
+	if nilList.Strike("1.2.3.4", "test") || nilList.Banned("1.2.3.4") {
+		t.Fatal("nil ban list")
+	}
+}
+
+func TestAccess_Allowed(t *testing.T) {
+	a, err := NewAccess([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.0.0.2":    true,
+		"10.0.0.1":    false,
+		"1.2.3.4":     false,
+		"2001:db8::1": true,
+		"unknown":     false,
+	} {
+		if got := a.Allowed(ip); got != want {
+			t.Errorf("%s: got %v", ip, got)
+		}
+	}
+	if a, _ = NewAccess(nil, nil); a != nil || !a.Allowed("1.2.3.4") {
+		t.Fatal("empty access")
+	}
+}
@@ -0,0 +1,155 @@
+package ip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+//客户端ip，只有请求来自可信的代理时才使用代理添加的header
+/*
+只读 Resolver.Header 指定的一个header，必须是可信的代理自己添加或覆盖的，
+代理原样转发的其他header由客户端控制，不能使用
+X-Forwarded-For: 192.0.2.60, 10.0.0.2                               默认，nginx 的 $proxy_add_x_forwarded_for
+Forwarded: for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"   RFC 7239
+X-Real-Ip: 192.0.2.60                                               代理覆盖为连接的地址
+X-Forwarded-For、Forwarded 从右往左跳过可信的代理，第一个不可信的地址为客户端ip
+*/
+
+// 可信的代理设置的header
+const (
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderForwarded     = "Forwarded"
+	HeaderXRealIp       = "X-Real-Ip"
+)
+
+// Resolver 按可信代理的网段解析客户端ip，可以在多个协程中使用
+type Resolver struct {
+	trusted Networks
+	Header  string //可信的代理设置的header，为空时为 X-Forwarded-For，创建后、使用前设置
+}
+
+// PrivateProxies 本机和内网的网段，DefaultResolver 信任这些代理
+var PrivateProxies = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+}
+
+// DefaultResolver 信任本机和内网的代理，读取 X-Forwarded-For，适用于 nginx 在本机或内网的部署。
+// 不要修改，代理使用其他header时用 NewResolver 创建
+var DefaultResolver = MustResolver(PrivateProxies...)
+
+// NewResolver 创建解析器，proxies 为可信代理的网段或ip，如 10.0.0.0/8、192.168.1.10、::1。
+// proxies 为空时不信任任何代理，客户端ip为连接的地址
+func NewResolver(proxies ...string) (*Resolver, error) {
+	trusted, err := ParseNetworks(proxies...)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{trusted: trusted}, nil
+}
+
+// MustResolver 与 NewResolver 相同，配置错误时 panic
+func MustResolver(proxies ...string) *Resolver {
+	r, err := NewResolver(proxies...)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Trusted ip 是否是可信的代理
+func (r *Resolver) Trusted(ip net.IP) bool {
+	return r.trusted.Contains(ip)
+}
+
+// ClientIp 请求的客户端ip，IPv4映射的IPv6地址返回IPv4
+func (r *Resolver) ClientIp(req *http.Request) string {
+	remote := ParseHost(req.RemoteAddr)
+	if remote == nil {
+		return req.RemoteAddr
+	}
+	if !r.Trusted(remote) {
+		return remote.String()
+	}
+	var hops []string
+	switch r.Header {
+	case HeaderXRealIp:
+		if ip := ParseHost(req.Header.Get(HeaderXRealIp)); ip != nil {
+			return ip.String()
+		}
+		return remote.String()
+	case HeaderForwarded:
+		hops = forwarded(req.Header)
+	default:
+		hops = forwardedFor(req.Header)
+	}
+	//从右往左，第一个不可信的地址为客户端
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := ParseHost(hops[i])
+		if ip == nil {
+			//unknown 或隐藏的地址，使用添加它的代理
+			break
+		}
+		client = ip
+		if !r.Trusted(ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwarded Forwarded 中代理链的地址
+func forwarded(h http.Header) []string {
+	var hops []string
+	for _, value := range h.Values(HeaderForwarded) {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					hops = append(hops, strings.Trim(v, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// forwardedFor X-Forwarded-For 中代理链的地址
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	for _, value := range h.Values(HeaderXForwardedFor) {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// ParseHost 解析带或不带端口的地址，如 1.2.3.4、1.2.3.4:80、[::1]:80、::1，不是ip时返回nil
+func ParseHost(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	//去掉IPv6的zone
+	if i := strings.LastIndex(addr, "%"); i >= 0 {
+		addr = addr[:i]
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
@@ -0,0 +1,47 @@
+package ip
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolver_ClientIp(t *testing.T) {
+	forwardedResolver := MustResolver("10.0.0.0/8", "::1")
+	forwardedResolver.Header = HeaderForwarded
+	realIpResolver := MustResolver("10.0.0.0/8")
+	realIpResolver.Header = HeaderXRealIp
+	for _, c := range []struct {
+		r      *Resolver
+		remote string
+		header map[string]string
+		want   string
+	}{
+		//不可信的连接，忽略header
+		{DefaultResolver, "1.2.3.4:5678", map[string]string{"X-Forwarded-For": "9.9.9.9"}, "1.2.3.4"},
+		{realIpResolver, "[2001:db8::1]:443", map[string]string{"X-Real-Ip": "9.9.9.9"}, "2001:db8::1"},
+		//可信的代理，跳过链中可信的地址
+		{DefaultResolver, "10.0.0.1:80", map[string]string{"X-Forwarded-For": "9.9.9.9, 1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{DefaultResolver, "10.0.0.1:80", map[string]string{"X-Forwarded-For": "10.0.0.3"}, "10.0.0.3"},
+		{realIpResolver, "10.0.0.1:80", map[string]string{"X-Real-Ip": "1.2.3.4"}, "1.2.3.4"},
+		{forwardedResolver, "[::1]:80", map[string]string{"Forwarded": `for=192.0.2.60;proto=http, for="[2001:db8::2]:4711"`}, "2001:db8::2"},
+		{forwardedResolver, "10.0.0.1:80", map[string]string{"Forwarded": "for=unknown", "X-Forwarded-For": "1.2.3.4"}, "10.0.0.1"},
+		//代理只追加 X-Forwarded-For，客户端伪造的 Forwarded、X-Real-Ip 被原样转发，不能使用
+		{DefaultResolver, "127.0.0.1:80", map[string]string{"Forwarded": "for=1.1.1.1", "X-Forwarded-For": "203.0.113.9"}, "203.0.113.9"},
+		{DefaultResolver, "127.0.0.1:80", map[string]string{"X-Real-Ip": "1.1.1.1"}, "127.0.0.1"},
+		//代理设置 Forwarded 时忽略 X-Forwarded-For
+		{forwardedResolver, "10.0.0.1:80", map[string]string{"Forwarded": "for=203.0.113.9", "X-Forwarded-For": "1.1.1.1"}, "203.0.113.9"},
+		{DefaultResolver, "[::ffff:10.0.0.1]:80", nil, "10.0.0.1"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = c.remote
+		for k, v := range c.header {
+			req.Header.Set(k, v)
+		}
+		if got := c.r.ClientIp(req); got != c.want {
+			t.Errorf("%s %v: got %s, want %s", c.remote, c.header, got, c.want)
+		}
+	}
+	if _, err := NewResolver("10.0.0.0/33"); err == nil {
+		t.Error("invalid cidr accepted")
+	}
+}
@@ -2,18 +2,11 @@ package ip
 
 import (
 	"net/http"
-	"strings"
 )
 
+// XRealIp 客户端ip，只信任本机和内网代理添加的 X-Forwarded-For
+//
+// Deprecated: 使用 Resolver.ClientIp 配置可信的代理
 func XRealIp(r *http.Request) (ip string) {
-	//X-Forwarded-For和X-Real-Ip可能是伪造的
-	ip = strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-For"), ",")[0])
-	if ip == "" {
-		ip = strings.TrimSpace(r.Header.Get("X-Real-Ip"))
-	}
-	if ip == "" {
-		ip = r.RemoteAddr
-	}
-	ip = strings.Split(ip, ":")[0]
-	return
+	return DefaultResolver.ClientIp(r)
 }
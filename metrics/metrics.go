@@ -0,0 +1,356 @@
+// Package metrics 运行指标，以 Prometheus 文本格式输出，不依赖外部服务
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/qiaojun2016/basic/color"
+	"github.com/qiaojun2016/basic/health"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//格式参考 https://prometheus.io/docs/instrumenting/exposition_formats/
+/*
+# HELP basic_http_requests_total 请求数
+# TYPE basic_http_requests_total counter
+basic_http_requests_total{route="POST /order",status="200"} 12
+*/
+
+type (
+	// Server 管理端口，提供 /metrics、/healthz、/readyz，与业务端口分开，不对外开放
+	Server struct {
+		Addr  string //监听地址，如 127.0.0.1:9100
+		Path  string //指标地址，默认 /metrics
+		Block bool   //当主协程能自己维持，block不用开启
+	}
+
+	// collector 一个指标
+	collector interface {
+		write(w *bufio.Writer)
+	}
+
+	// vec 按标签值分组的指标
+	vec struct {
+		name   string
+		help   string
+		typ    string
+		labels []string
+		mu     sync.Mutex
+		values map[string]*sample
+	}
+
+	sample struct {
+		labels []string
+		value  float64
+		//直方图
+		buckets []float64
+		counts  []uint64
+		sum     float64
+		count   uint64
+	}
+
+	// Counter 只增不减的计数
+	Counter struct{ v *vec }
+
+	// Gauge 可增可减的数值
+	Gauge struct{ v *vec }
+
+	// Histogram 分布，如请求耗时
+	Histogram struct {
+		v       *vec
+		buckets []float64
+	}
+
+	// valueFunc 在输出时计算的数值，typ 为 gauge 或 counter
+	valueFunc struct {
+		name string
+		help string
+		typ  string
+		f    func() float64
+	}
+)
+
+// DefBuckets 默认的耗时分布，单位秒
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	mu         sync.RWMutex
+	collectors = make(map[string]collector)
+	handlers   = make(map[string]http.Handler)
+)
+
+// register 同名的指标会被替换
+func register(name string, c collector) {
+	mu.Lock()
+	collectors[name] = c
+	mu.Unlock()
+}
+
+func newVec(name, help, typ string, labels []string) *vec {
+	return &vec{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		values: make(map[string]*sample),
+	}
+}
+
+// with 取标签值对应的数据，调用时持有锁
+func (v *vec) with(values []string) *sample {
+	if len(values) != len(v.labels) {
+		log.Panicf("'%s' expects %d label values, got %d", v.name, len(v.labels), len(values))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.values[key]
+	if !ok {
+		s = &sample{labels: append([]string{}, values...)}
+		v.values[key] = s
+	}
+	return s
+}
+
+// NewCounter 创建计数，labels 为标签名
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{v: newVec(name, help, "counter", labels)}
+	register(name, c.v)
+	return c
+}
+
+// Inc 加1，values 为标签值，与创建时的标签名一一对应
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add 增加，delta 不能小于0
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	c.v.mu.Lock()
+	c.v.with(values).value += delta
+	c.v.mu.Unlock()
+}
+
+// NewGauge 创建数值
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{v: newVec(name, help, "gauge", labels)}
+	register(name, g.v)
+	return g
+}
+
+// Set 设置数值
+func (g *Gauge) Set(value float64, values ...string) {
+	g.v.mu.Lock()
+	g.v.with(values).value = value
+	g.v.mu.Unlock()
+}
+
+// Add 增加，可以为负数
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.v.mu.Lock()
+	g.v.with(values).value += delta
+	g.v.mu.Unlock()
+}
+
+// Inc 加1
+func (g *Gauge) Inc(values ...string) {
+	g.Add(1, values...)
+}
+
+// Dec 减1
+func (g *Gauge) Dec(values ...string) {
+	g.Add(-1, values...)
+}
+
+// NewGaugeFunc 创建在输出时计算的数值，用于连接池等已有的统计
+func NewGaugeFunc(name, help string, f func() float64) {
+	register(name, &valueFunc{name: name, help: help, typ: "gauge", f: f})
+}
+
+// NewCounterFunc 创建在输出时计算的计数，f 的结果只增不减，用于连接池的累计次数等，name 以 _total 结尾
+func NewCounterFunc(name, help string, f func() float64) {
+	register(name, &valueFunc{name: name, help: help, typ: "counter", f: f})
+}
+
+// NewHistogram 创建分布，buckets 为空时使用 DefBuckets
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{v: newVec(name, help, "histogram", labels), buckets: buckets}
+	register(name, h.v)
+	return h
+}
+
+// Observe 记录一个值
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.v.mu.Lock()
+	s := h.v.with(values)
+	if s.counts == nil {
+		s.buckets = h.buckets
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	for i, b := range s.buckets {
+		if value <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+	h.v.mu.Unlock()
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	header(w, v.name, v.help, v.typ)
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := v.values[k]
+		if v.typ != "histogram" {
+			line(w, v.name, v.labels, s.labels, "", "", s.value)
+			continue
+		}
+		for i, b := range s.buckets {
+			line(w, v.name+"_bucket", v.labels, s.labels, "le", formatFloat(b), float64(s.counts[i]))
+		}
+		line(w, v.name+"_bucket", v.labels, s.labels, "le", "+Inf", float64(s.count))
+		line(w, v.name+"_sum", v.labels, s.labels, "", "", s.sum)
+		line(w, v.name+"_count", v.labels, s.labels, "", "", float64(s.count))
+	}
+}
+
+func (g *valueFunc) write(w *bufio.Writer) {
+	header(w, g.name, g.help, g.typ)
+	line(w, g.name, nil, nil, "", "", g.f())
+}
+
+func header(w *bufio.Writer, name, help, typ string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// line 输出一行，extraName 为直方图的 le 标签
+func line(w *bufio.Writer, name string, names, values []string, extraName, extraValue string, value float64) {
+	_, _ = w.WriteString(name)
+	if len(names) > 0 || extraName != "" {
+		_ = w.WriteByte('{')
+		for i, n := range names {
+			if i > 0 {
+				_ = w.WriteByte(',')
+			}
+			_, _ = fmt.Fprintf(w, `%s="%s"`, n, labelEscaper.Replace(values[i]))
+		}
+		if extraName != "" {
+			if len(names) > 0 {
+				_ = w.WriteByte(',')
+			}
+			_, _ = fmt.Fprintf(w, `%s="%s"`, extraName, extraValue)
+		}
+		_ = w.WriteByte('}')
+	}
+	_ = w.WriteByte(' ')
+	_, _ = w.WriteString(formatFloat(value))
+	_ = w.WriteByte('\n')
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteTo 按名称顺序输出所有指标
+func WriteTo(w io.Writer) error {
+	mu.RLock()
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]collector, len(names))
+	for i, name := range names {
+		list[i] = collectors[name]
+	}
+	mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range list {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler 输出指标的 http.Handler
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WriteTo(w); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+// Handle 在管理端口上增加接口，在 Server.Run 之前调用
+func Handle(pattern string, handler http.Handler) {
+	mu.Lock()
+	handlers[pattern] = handler
+	mu.Unlock()
+}
+
+// Run 启动管理端口，Addr 为空时不启动
+func (s Server) Run() {
+	if s.Addr == "" {
+		return
+	}
+	if s.Path == "" {
+		s.Path = "/metrics"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(s.Path, Handler())
+	mu.RLock()
+	for pattern, handler := range handlers {
+		mux.Handle(pattern, handler)
+	}
+	//Handle 注册了同名接口时使用注册的
+	if _, ok := handlers["/healthz"]; !ok {
+		mux.Handle("/healthz", health.Healthz())
+	}
+	if _, ok := handlers["/readyz"]; !ok {
+		mux.Handle("/readyz", health.Readyz())
+	}
+	mu.RUnlock()
+
+	go func() {
+		if err := http.ListenAndServe(s.Addr, mux); err != nil {
+			log.Println("[metrics] Listen error!", err)
+		}
+	}()
+	color.Success(fmt.Sprintf("[metrics] listening http://%s%s", s.Addr, s.Path))
+
+	if s.Block {
+		select {}
+	}
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	c := NewCounter("test_requests_total", "请求数", "route", "status")
+	c.Inc("POST /order", "200")
+	c.Add(2, "POST /order", "200")
+	c.Inc(`GET /a"b`, "429")
+	g := NewGauge("test_connections", "连接数")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	h := NewHistogram("test_duration_seconds", "耗时", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/a")
+	h.Observe(0.5, "/a")
+	NewGaugeFunc("test_idle", "空闲", func() float64 { return 3 })
+	NewCounterFunc("test_hits_total", "命中次数", func() float64 { return 5 })
+
+	var buf bytes.Buffer
+	if err := WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{route="POST /order",status="200"} 3` + "\n",
+		`test_requests_total{route="GET /a\"b",status="429"} 1` + "\n",
+		"test_connections 1\n",
+		`test_duration_seconds_bucket{route="/a",le="0.1"} 1` + "\n",
+		`test_duration_seconds_bucket{route="/a",le="1"} 2` + "\n",
+		`test_duration_seconds_bucket{route="/a",le="+Inf"} 2` + "\n",
+		`test_duration_seconds_sum{route="/a"} 0.55` + "\n",
+		`test_duration_seconds_count{route="/a"} 2` + "\n",
+		"# TYPE test_idle gauge\ntest_idle 3\n",
+		"# TYPE test_hits_total counter\ntest_hits_total 5\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
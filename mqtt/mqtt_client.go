@@ -1,8 +1,10 @@
 package mqtt
 
 import (
+	"context"
 	"fmt"
 	"github.com/qiaojun2016/basic/color"
+	"github.com/qiaojun2016/basic/health"
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 	"log"
 )
@@ -89,6 +91,12 @@ func (s Client) Run() error {
 		return token.Error()
 	}
 	MqttClient = new(mqttClient)
+	health.Register("mqtt", health.CheckerFunc(func(ctx context.Context) error {
+		if !client.IsConnectionOpen() {
+			return fmt.Errorf("mqtt connection lost")
+		}
+		return nil
+	}))
 
 	//注册监听
 	for s2, callback := range subscribeMap {
@@ -1,11 +1,14 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/qiaojun2016/basic/color"
+	"github.com/qiaojun2016/basic/health"
+	"github.com/qiaojun2016/basic/metrics"
 	"log"
 	"reflect"
 	"strings"
@@ -26,8 +29,13 @@ var (
 )
 
 func TxAuto(f func(*sql.Rows, *sql.Tx) (err error)) (err error) {
+	return TxAutoCtx(context.Background(), f)
+}
+
+// TxAutoCtx 同 TxAuto，ctx 取消或超时时事物回滚
+func TxAutoCtx(ctx context.Context, f func(*sql.Rows, *sql.Tx) (err error)) (err error) {
 	//开启事物
-	tx, err := Mysql.TxBegin()
+	tx, err := Mysql.TxBeginCtx(ctx)
 	if err != nil {
 		log.Println(err)
 		return
@@ -47,6 +55,11 @@ func (s server) TxBegin() (*sql.Tx, error) {
 	return mysqlDB.Begin()
 }
 
+// TxBeginCtx 开启事物，ctx 取消或超时时事物回滚
+func (s server) TxBeginCtx(ctx context.Context) (*sql.Tx, error) {
+	return mysqlDB.BeginTx(ctx, nil)
+}
+
 // TxEnd 关闭事物
 func (s server) TxEnd(tx *sql.Tx, err error) {
 	if tx == nil {
@@ -116,9 +129,32 @@ func (s Server) Run() {
 		log.Fatal(color.Red, sqlErr, color.Reset)
 	}
 	Mysql = new(server)
+	registerMetrics()
+	health.Register("mysql", health.CheckerFunc(func(ctx context.Context) error {
+		return mysqlDB.PingContext(ctx)
+	}))
 	color.Success(fmt.Sprintf("[mysql] connect %s success", strings.Split(s.DataSource, "@tcp")[1]))
 }
 
+// registerMetrics 连接池指标
+func registerMetrics() {
+	metrics.NewGaugeFunc("basic_mysql_open_connections", "打开的连接数", func() float64 {
+		return float64(mysqlDB.Stats().OpenConnections)
+	})
+	metrics.NewGaugeFunc("basic_mysql_in_use_connections", "使用中的连接数", func() float64 {
+		return float64(mysqlDB.Stats().InUse)
+	})
+	metrics.NewGaugeFunc("basic_mysql_idle_connections", "空闲的连接数", func() float64 {
+		return float64(mysqlDB.Stats().Idle)
+	})
+	metrics.NewCounterFunc("basic_mysql_wait_count_total", "等待连接的总次数", func() float64 {
+		return float64(mysqlDB.Stats().WaitCount)
+	})
+	metrics.NewCounterFunc("basic_mysql_wait_duration_seconds_total", "等待连接的总时间", func() float64 {
+		return mysqlDB.Stats().WaitDuration.Seconds()
+	})
+}
+
 // 格式化参数
 func argsData(args []interface{}) (sqlArgs string, values []interface{}) {
 	for _, arg := range args {
@@ -3,6 +3,7 @@ package oss
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
@@ -146,6 +147,15 @@ func (s server) UploadUrl(path, url string) (string, error) {
 	return path, nil
 }
 
+// Save 上传文件流，实现 route.Storage，返回 key。sdk 不支持 ctx，上传不会被取消
+func (s server) Save(ctx context.Context, key, contentType string, r io.Reader) (string, error) {
+	if err := bucket.PutObject(key, r, oss.ContentType(contentType)); err != nil {
+		log.Println(err)
+		return "", err
+	}
+	return key, nil
+}
+
 func (s Server) Run() {
 	if Oss != nil {
 		return
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/qiaojun2016/basic/color"
+	"github.com/qiaojun2016/basic/health"
+	"github.com/qiaojun2016/basic/metrics"
 	"log"
 	"time"
 )
@@ -40,15 +42,25 @@ var (
 //常用函数的封装
 
 func (s server) Get(key string) (bytes []byte, err error) {
-	return redisClient.Get(context.Background(), key).Bytes()
+	return s.GetCtx(context.Background(), key)
+}
+
+// GetCtx 同 Get，ctx 取消或超时时中断
+func (s server) GetCtx(ctx context.Context, key string) (bytes []byte, err error) {
+	return redisClient.Get(ctx, key).Bytes()
 }
 
 func (s server) Set(key string, value interface{}, expiration ...time.Duration) (err error) {
+	return s.SetCtx(context.Background(), key, value, expiration...)
+}
+
+// SetCtx 同 Set，ctx 取消或超时时中断
+func (s server) SetCtx(ctx context.Context, key string, value interface{}, expiration ...time.Duration) (err error) {
 	exp := time.Duration(0)
 	if len(expiration) == 1 {
 		exp = expiration[0]
 	}
-	err = redisClient.Set(context.Background(), key, value, exp).Err()
+	err = redisClient.Set(ctx, key, value, exp).Err()
 	if err != nil {
 		log.Println(err)
 		return
@@ -155,6 +167,25 @@ func (s server) Client() *redis.Client {
 	return redisClient
 }
 
+// registerMetrics 连接池指标
+func registerMetrics() {
+	metrics.NewGaugeFunc("basic_redis_total_connections", "连接池中的连接数", func() float64 {
+		return float64(redisClient.PoolStats().TotalConns)
+	})
+	metrics.NewGaugeFunc("basic_redis_idle_connections", "空闲的连接数", func() float64 {
+		return float64(redisClient.PoolStats().IdleConns)
+	})
+	metrics.NewCounterFunc("basic_redis_pool_hits_total", "从连接池取到空闲连接的次数", func() float64 {
+		return float64(redisClient.PoolStats().Hits)
+	})
+	metrics.NewCounterFunc("basic_redis_pool_misses_total", "连接池中没有空闲连接的次数", func() float64 {
+		return float64(redisClient.PoolStats().Misses)
+	})
+	metrics.NewCounterFunc("basic_redis_pool_timeouts_total", "等待连接超时的次数", func() float64 {
+		return float64(redisClient.PoolStats().Timeouts)
+	})
+}
+
 func (s Server) Run() {
 	if Redis != nil {
 		return
@@ -191,6 +222,10 @@ func (s Server) Run() {
 		}
 	}
 	Redis = new(server)
+	registerMetrics()
+	health.Register("redis", health.CheckerFunc(func(ctx context.Context) error {
+		return redisClient.Ping(ctx).Err()
+	}))
 	color.Success(fmt.Sprintf("[redis] connect %s db %d success", s.Addr, s.DB))
 
 	if s.Block {
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"github.com/qiaojun2016/basic/trace"
 	"io"
 	"io/ioutil"
 	"log"
@@ -15,6 +16,11 @@ import (
 )
 
 func HttpPostJson(url string, data interface{}, headers ...map[string]string) ([]byte, error) {
+	return HttpPostJsonCtx(context.Background(), url, data, headers...)
+}
+
+// HttpPostJsonCtx 同 HttpPostJson，ctx 取消或超时时中断请求，ctx 中的请求id放入 X-Request-Id
+func HttpPostJsonCtx(ctx context.Context, url string, data interface{}, headers ...map[string]string) ([]byte, error) {
 	b, err := json.Marshal(data)
 	if err != nil {
 		log.Println(err)
@@ -35,10 +41,15 @@ func HttpPostJson(url string, data interface{}, headers ...map[string]string) ([
 		}
 	}
 
-	return doRequest(req)
+	return doRequest(ctx, req)
 }
 
 func HttpGet(urlStr string, data map[string]string, headers ...map[string]string) ([]byte, error) {
+	return HttpGetCtx(context.Background(), urlStr, data, headers...)
+}
+
+// HttpGetCtx 同 HttpGet，ctx 取消或超时时中断请求，ctx 中的请求id放入 X-Request-Id
+func HttpGetCtx(ctx context.Context, urlStr string, data map[string]string, headers ...map[string]string) ([]byte, error) {
 	//url
 	Url, err := url.Parse(urlStr)
 	if err != nil {
@@ -72,10 +83,15 @@ func HttpGet(urlStr string, data map[string]string, headers ...map[string]string
 		}
 	}
 
-	return doRequest(req)
+	return doRequest(ctx, req)
 }
 
 func HttpPostXML(url string, data interface{}, headers ...map[string]string) ([]byte, error) {
+	return HttpPostXMLCtx(context.Background(), url, data, headers...)
+}
+
+// HttpPostXMLCtx 同 HttpPostXML，ctx 取消或超时时中断请求，ctx 中的请求id放入 X-Request-Id
+func HttpPostXMLCtx(ctx context.Context, url string, data interface{}, headers ...map[string]string) ([]byte, error) {
 	b, err := xml.Marshal(data)
 	if err != nil {
 		log.Println(err)
@@ -93,14 +109,18 @@ func HttpPostXML(url string, data interface{}, headers ...map[string]string) ([]
 		}
 	}
 
-	return doRequest(req)
+	return doRequest(ctx, req)
 }
 
-func doRequest(req *http.Request) (body []byte, err error) {
-	//超时
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+func doRequest(ctx context.Context, req *http.Request) (body []byte, err error) {
+	//超时，ctx 的截止时间更早时以 ctx 为准
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
 	req = req.WithContext(ctx)
+	//请求id
+	if id := trace.Id(ctx); id != "" && req.Header.Get(trace.Header) == "" {
+		req.Header.Set(trace.Header, id)
+	}
 
 	//客户端
 	client := &http.Client{
@@ -3,8 +3,10 @@ package task
 import (
 	"fmt"
 	"github.com/qiaojun2016/basic/color"
+	"github.com/qiaojun2016/basic/metrics"
 	"github.com/robfig/cron"
 	"log"
+	"time"
 )
 
 type (
@@ -28,6 +30,13 @@ type (
 var tasks taskMap
 var tasksImmediate taskImmediate
 
+// 指标
+var (
+	runsTotal     = metrics.NewCounter("basic_task_runs_total", "任务执行次数", "task")
+	failuresTotal = metrics.NewCounter("basic_task_failures_total", "任务执行失败（panic）次数", "task")
+	taskDuration  = metrics.NewHistogram("basic_task_duration_seconds", "任务耗时", nil, "task")
+)
+
 func init() {
 	tasks = taskMap{}
 	tasksImmediate = taskImmediate{}
@@ -43,6 +52,7 @@ func (t Task) Register(cmd func()) {
 		log.Panicf("'%s' 任务已经存在", t.Name)
 		return
 	}
+	cmd = t.observe(cmd)
 	//创建任务
 	c := cron.New()
 	err := c.AddFunc(t.Spec, cmd)
@@ -57,6 +67,22 @@ func (t Task) Register(cmd func()) {
 	}
 }
 
+// observe 记录执行次数、失败和耗时，panic 记为失败
+func (t Task) observe(cmd func()) func() {
+	return func() {
+		start := time.Now()
+		defer func() {
+			taskDuration.Observe(time.Since(start).Seconds(), t.Name)
+			if r := recover(); r != nil {
+				failuresTotal.Inc(t.Name)
+				log.Println(fmt.Sprintf("[%s]任务失败: %v", t.Name, r))
+			}
+		}()
+		runsTotal.Inc(t.Name)
+		cmd()
+	}
+}
+
 // Cancel 取消
 func (t Task) Cancel() {
 	if c, ok := tasks[t.Name]; ok {
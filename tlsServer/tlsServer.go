@@ -0,0 +1,200 @@
+// Package tlsServer http、ws、fileServer 共用的TLS配置：证书文件热加载、HTTP/2、HTTP跳转HTTPS
+package tlsServer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// TLS 证书配置，为空时监听普通的http
+	TLS struct {
+		CertFile     string        //证书文件，包含中间证书
+		KeyFile      string        //私钥文件
+		ReloadPeriod time.Duration //检查证书文件变化的周期，默认1分钟，小于0时不检查
+		DisableHTTP2 bool          //关闭 HTTP/2，默认开启
+		RedirectAddr string        //HTTP跳转HTTPS的监听地址，如 :80，为空时不跳转
+		MinVersion   uint16        //最低TLS版本，默认 tls.VersionTLS12
+	}
+
+	// Reloader 证书文件变化时重新加载，握手时使用最新的证书
+	Reloader struct {
+		certFile string
+		keyFile  string
+		mu       sync.RWMutex
+		cert     *tls.Certificate
+		modTime  time.Time
+	}
+)
+
+// Scheme 监听的协议，http 或 https
+func (t *TLS) Scheme() string {
+	if t == nil {
+		return "http"
+	}
+	return "https"
+}
+
+// NewReloader 加载证书，文件错误时返回错误
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 重新加载证书，失败时继续使用之前的证书
+func (r *Reloader) Reload() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate 用于 tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch 每隔 period 检查文件的修改时间，变化时重新加载，直到 done 关闭
+func (r *Reloader) Watch(period time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			modTime, err := r.lastModified()
+			if err != nil {
+				log.Println("[tls]", err)
+				continue
+			}
+			r.mu.RLock()
+			changed := !modTime.Equal(r.modTime)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			//证书和私钥可能没有同时写完，失败时下个周期再试
+			if err = r.Reload(); err != nil {
+				log.Println("[tls] reload error!", err)
+				continue
+			}
+			log.Println("[tls] certificate reloaded", r.certFile)
+		}
+	}
+}
+
+// lastModified 证书和私钥中较晚的修改时间
+func (r *Reloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return last, err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last, nil
+}
+
+// Config 生成 tls.Config，证书由 reloader 提供
+func (t *TLS) Config(reloader *Reloader) *tls.Config {
+	config := &tls.Config{
+		MinVersion:     t.MinVersion,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if t.DisableHTTP2 {
+		config.NextProtos = []string{"http/1.1"}
+	} else {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return config
+}
+
+// ListenAndServe t 为空时调用 srv.ListenAndServe，否则以TLS监听，
+// 并按配置热加载证书、启动跳转监听。返回值与 srv.ListenAndServe 相同，关闭时为 http.ErrServerClosed
+func ListenAndServe(srv *http.Server, t *TLS) error {
+	if t == nil {
+		return srv.ListenAndServe()
+	}
+	reloader, err := NewReloader(t.CertFile, t.KeyFile)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = t.Config(reloader)
+	if t.DisableHTTP2 {
+		//非nil的空map关闭 HTTP/2
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	period := t.ReloadPeriod
+	if period == 0 {
+		period = time.Minute
+	}
+	if period > 0 {
+		go reloader.Watch(period, done)
+	}
+
+	if t.RedirectAddr != "" {
+		redirect := &http.Server{
+			Addr:              t.RedirectAddr,
+			Handler:           RedirectHandler(srv.Addr),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Println("[tls] redirect listen error!", err)
+			}
+		}()
+		defer func() {
+			_ = redirect.Close()
+		}()
+	}
+	return srv.ListenAndServeTLS("", "")
+}
+
+// RedirectHandler 把请求跳转到 https，addr 为HTTPS的监听地址，端口为443时省略
+func RedirectHandler(addr string) http.Handler {
+	_, port, _ := net.SplitHostPort(addr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			//IPv6
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, fmt.Sprintf("https://%s%s", host, r.URL.RequestURI()), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,372 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/qiaojun2016/basic/badger"
+	"github.com/qiaojun2016/basic/id"
+	"github.com/qiaojun2016/basic/redis"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//令牌的有效期和吊销
+/*
+登录时 Manager.Issue 签发访问令牌和刷新令牌，访问令牌过期后用 Manager.Refresh 换取新的一对，
+旧的刷新令牌随即吊销，新令牌保持原来的session。
+吊销记录：
+basic:revoke:token:{session}:{timestamp}[:refresh] 单个令牌
+basic:revoke:session:{session}                     一个会话签发的所有令牌
+basic:revoke:user:{id}                             用户在该时间（纳秒）之前签发的所有令牌
+记录保留到被吊销的令牌全部过期，TTL 为0时永久保留
+*/
+
+const (
+	revokePrefix      = "basic:revoke:"
+	defaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalid  = errors.New("token invalid")
+	ErrExpired  = errors.New("token expired")
+	ErrRevoked  = errors.New("token revoked")
+	ErrNoStore  = errors.New("revoke store not run") //配置了 Manager 但没有可用的吊销存储，校验和吊销都失败
+	ErrNoSecret = errors.New("token secret not set, run token.Server first")
+)
+
+type (
+	// RevokeStore 保存吊销记录
+	RevokeStore interface {
+		// Revoke 记录吊销，value 为吊销时间（纳秒），ttl 后过期，为0时不过期
+		Revoke(key string, value int64, ttl time.Duration) error
+		// Revoked 读取多个key的吊销时间，不存在的为0
+		Revoked(keys ...string) ([]int64, error)
+		// Claim key 不存在时原子地写入，返回是否写入，用于刷新令牌只能使用一次
+		Claim(key string, value int64, ttl time.Duration) (bool, error)
+	}
+
+	// RedisRevokeStore 使用redis保存，多个实例共享
+	RedisRevokeStore struct{}
+
+	// BadgerRevokeStore 使用badger保存，只在当前进程有效
+	BadgerRevokeStore struct{}
+
+	// LocalRevokeStore 保存在进程内，用于测试和单实例
+	LocalRevokeStore struct {
+		mu      sync.Mutex
+		entries map[string]revokeEntry
+	}
+
+	revokeEntry struct {
+		value  int64
+		expire time.Time //为零时不过期
+	}
+
+	// Manager 令牌的有效期、刷新和吊销，http.Server 和 ws.Server 可以共用一个
+	Manager struct {
+		TTL        time.Duration //访问令牌的有效期，为0时不过期
+		RefreshTTL time.Duration //刷新令牌的有效期，默认30天
+		Store      RevokeStore   //吊销记录，为空时按 redis、badger 的顺序选择已启动的存储，都没有时拒绝所有令牌
+	}
+
+	// Pair 一对令牌，登录和刷新时返回给客户端
+	Pair struct {
+		Token            string    `json:"token"`
+		RefreshToken     string    `json:"refreshToken"`
+		ExpiresAt        time.Time `json:"expiresAt,omitempty"` //访问令牌的过期时间，不过期时为零值
+		RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+	}
+)
+
+func (RedisRevokeStore) Revoke(key string, value int64, ttl time.Duration) error {
+	if redis.Redis == nil {
+		return fmt.Errorf("redis not run")
+	}
+	return redis.Redis.Client().Set(context.Background(), revokePrefix+key, value, ttl).Err()
+}
+
+func (RedisRevokeStore) Revoked(keys ...string) ([]int64, error) {
+	if redis.Redis == nil {
+		return nil, fmt.Errorf("redis not run")
+	}
+	full := make([]string, len(keys))
+	for i, key := range keys {
+		full[i] = revokePrefix + key
+	}
+	result, err := redis.Redis.Client().MGet(context.Background(), full...).Result()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]int64, len(keys))
+	for i, v := range result {
+		if s, ok := v.(string); ok {
+			values[i], _ = strconv.ParseInt(s, 10, 64)
+		}
+	}
+	return values, nil
+}
+
+func (RedisRevokeStore) Claim(key string, value int64, ttl time.Duration) (bool, error) {
+	if redis.Redis == nil {
+		return false, fmt.Errorf("redis not run")
+	}
+	return redis.Redis.Client().SetNX(context.Background(), revokePrefix+key, value, ttl).Result()
+}
+
+func (BadgerRevokeStore) Revoke(key string, value int64, ttl time.Duration) error {
+	if badger.Dadger == nil {
+		return fmt.Errorf("badger not run")
+	}
+	v := []byte(strconv.FormatInt(value, 10))
+	if ttl > 0 {
+		return badger.Dadger.SetTTL([]byte(revokePrefix), []byte(key), v, ttl)
+	}
+	return badger.Dadger.Set([]byte(revokePrefix), []byte(key), v)
+}
+
+func (BadgerRevokeStore) Revoked(keys ...string) ([]int64, error) {
+	if badger.Dadger == nil {
+		return nil, fmt.Errorf("badger not run")
+	}
+	values := make([]int64, len(keys))
+	for i, key := range keys {
+		ok, err := badger.Dadger.Has([]byte(revokePrefix), []byte(key))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		v, err := badger.Dadger.Get([]byte(revokePrefix), []byte(key))
+		if err != nil {
+			return nil, err
+		}
+		values[i], _ = strconv.ParseInt(string(v), 10, 64)
+	}
+	return values, nil
+}
+
+func (BadgerRevokeStore) Claim(key string, value int64, ttl time.Duration) (bool, error) {
+	if badger.Dadger == nil {
+		return false, fmt.Errorf("badger not run")
+	}
+	return badger.Dadger.SetNX([]byte(revokePrefix), []byte(key), []byte(strconv.FormatInt(value, 10)), ttl)
+}
+
+// NewLocalRevokeStore 创建进程内的存储
+func NewLocalRevokeStore() *LocalRevokeStore {
+	return &LocalRevokeStore{entries: map[string]revokeEntry{}}
+}
+
+func (s *LocalRevokeStore) Revoke(key string, value int64, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := revokeEntry{value: value}
+	if ttl > 0 {
+		entry.expire = time.Now().Add(ttl)
+	}
+	s.entries[key] = entry
+	return nil
+}
+
+func (s *LocalRevokeStore) Revoked(keys ...string) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	values := make([]int64, len(keys))
+	for i, key := range keys {
+		entry, ok := s.entries[key]
+		if !ok {
+			continue
+		}
+		if !entry.expire.IsZero() && now.After(entry.expire) {
+			delete(s.entries, key)
+			continue
+		}
+		values[i] = entry.value
+	}
+	return values, nil
+}
+
+func (s *LocalRevokeStore) Claim(key string, value int64, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[key]; ok && (entry.expire.IsZero() || time.Now().Before(entry.expire)) {
+		return false, nil
+	}
+	entry := revokeEntry{value: value}
+	if ttl > 0 {
+		entry.expire = time.Now().Add(ttl)
+	}
+	s.entries[key] = entry
+	return true, nil
+}
+
+func tokenKey(t *Token) string {
+	if t.refresh {
+		return fmt.Sprintf("token:%d:%d:refresh", t.session, t.timestamp)
+	}
+	return fmt.Sprintf("token:%d:%d", t.session, t.timestamp)
+}
+
+func sessionKey(session int64) string {
+	return fmt.Sprintf("session:%d", session)
+}
+
+func userKey(userId int64) string {
+	return fmt.Sprintf("user:%d", userId)
+}
+
+// store 配置的存储，没有配置时优先使用redis，其次badger，都没有启动时返回nil
+func (m *Manager) store() RevokeStore {
+	if m.Store != nil {
+		return m.Store
+	}
+	if redis.Redis != nil {
+		return RedisRevokeStore{}
+	}
+	if badger.Dadger != nil {
+		return BadgerRevokeStore{}
+	}
+	return nil
+}
+
+func (m *Manager) refreshTTL() time.Duration {
+	if m.RefreshTTL > 0 {
+		return m.RefreshTTL
+	}
+	return defaultRefreshTTL
+}
+
+// keep 吊销记录的保留时间，覆盖访问令牌和刷新令牌的有效期
+func (m *Manager) keep() time.Duration {
+	if m.TTL <= 0 {
+		return 0
+	}
+	if m.TTL > m.refreshTTL() {
+		return m.TTL
+	}
+	return m.refreshTTL()
+}
+
+// Verify 解析访问令牌并检查有效期和吊销，m 为nil时只解析和校验签名。
+// 返回 ErrInvalid、ErrExpired、ErrRevoked、ErrNoStore、ErrNoSecret，或存储的错误
+func (m *Manager) Verify(t string) (Token, error) {
+	tk := Token{}
+	if err := tk.Decode(t); err == ErrNoSecret {
+		return tk, err
+	} else if err != nil || tk.IsRefresh() {
+		return tk, ErrInvalid
+	}
+	if m == nil {
+		return tk, nil
+	}
+	if tk.Expired(m.TTL) {
+		return tk, ErrExpired
+	}
+	return tk, m.checkRevoked(&tk)
+}
+
+// checkRevoked 令牌、会话或用户被吊销时返回 ErrRevoked，没有可用的存储时返回 ErrNoStore
+func (m *Manager) checkRevoked(tk *Token) error {
+	store := m.store()
+	if store == nil {
+		return ErrNoStore
+	}
+	values, err := store.Revoked(tokenKey(tk), sessionKey(tk.session), userKey(tk.Id))
+	if err != nil {
+		log.Println("[token]", err)
+		return err
+	}
+	if values[0] > 0 || values[1] > 0 || (values[2] > 0 && tk.timestamp <= values[2]) {
+		return ErrRevoked
+	}
+	return nil
+}
+
+// Issue 登录时签发一对令牌，使用新的session
+func (m *Manager) Issue(userId int64) Pair {
+	return m.issue(userId, id.SId.Int())
+}
+
+func (m *Manager) issue(userId, session int64) Pair {
+	access := Token{Id: userId}
+	refresh := Token{Id: userId}
+	pair := Pair{
+		Token:        access.encode(session, false),
+		RefreshToken: refresh.encode(session, true),
+	}
+	if m.TTL > 0 {
+		pair.ExpiresAt = access.IssuedAt().Add(m.TTL)
+	}
+	pair.RefreshExpiresAt = refresh.IssuedAt().Add(m.refreshTTL())
+	return pair
+}
+
+// Refresh 用刷新令牌换取新的一对令牌，旧的刷新令牌被吊销，只能使用一次
+func (m *Manager) Refresh(refreshToken string) (Pair, error) {
+	tk := Token{}
+	if err := tk.Decode(refreshToken); err == ErrNoSecret {
+		return Pair{}, err
+	} else if err != nil || !tk.IsRefresh() {
+		return Pair{}, ErrInvalid
+	}
+	if tk.Expired(m.refreshTTL()) {
+		return Pair{}, ErrExpired
+	}
+	if err := m.checkRevoked(&tk); err != nil {
+		return Pair{}, err
+	}
+	//原子地占用刷新令牌，并发刷新时只有一个成功
+	ok, err := m.store().Claim(tokenKey(&tk), time.Now().UnixNano(), time.Until(tk.IssuedAt().Add(m.refreshTTL())))
+	if err != nil {
+		return Pair{}, err
+	}
+	if !ok {
+		return Pair{}, ErrRevoked
+	}
+	return m.issue(tk.Id, tk.session), nil
+}
+
+// Revoke 吊销一个令牌，访问令牌和刷新令牌都可以
+func (m *Manager) Revoke(t string) error {
+	tk := Token{}
+	if err := tk.Decode(t); err == ErrNoSecret {
+		return err
+	} else if err != nil {
+		return ErrInvalid
+	}
+	ttl := m.TTL
+	if tk.IsRefresh() {
+		ttl = m.refreshTTL()
+	}
+	if ttl > 0 {
+		ttl = time.Until(tk.IssuedAt().Add(ttl))
+		if ttl <= 0 {
+			//已过期
+			return nil
+		}
+	}
+	return m.revoke(tokenKey(&tk), ttl)
+}
+
+// RevokeSession 吊销一个会话的所有令牌，包括之后刷新得到的
+func (m *Manager) RevokeSession(session int64) error {
+	return m.revoke(sessionKey(session), m.keep())
+}
+
+// RevokeUser 吊销用户当前已签发的所有令牌，之后签发的不受影响
+func (m *Manager) RevokeUser(userId int64) error {
+	return m.revoke(userKey(userId), m.keep())
+}
+
+func (m *Manager) revoke(key string, ttl time.Duration) error {
+	store := m.store()
+	if store == nil {
+		return ErrNoStore
+	}
+	return store.Revoke(key, time.Now().UnixNano(), ttl)
+}
@@ -0,0 +1,136 @@
+package token
+
+import (
+	"github.com/qiaojun2016/basic/cipher"
+	"github.com/qiaojun2016/basic/id"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	id.Server{Node: 1}.Run()
+	Server{Secret: testSecret}.Run()
+	m := &Manager{TTL: time.Hour, Store: NewLocalRevokeStore()}
+
+	pair := m.Issue(7)
+	tk, err := m.Verify(pair.Token)
+	if err != nil || tk.Id != 7 {
+		t.Fatal(tk, err)
+	}
+	//刷新令牌不能作为访问令牌
+	if _, err = m.Verify(pair.RefreshToken); err != ErrInvalid {
+		t.Fatal("refresh token accepted", err)
+	}
+	//过期
+	if _, err = (&Manager{TTL: time.Nanosecond}).Verify(pair.Token); err != ErrExpired {
+		t.Fatal("expired token accepted", err)
+	}
+
+	//刷新后保持session，旧的刷新令牌只能使用一次
+	refreshed, err := m.Refresh(pair.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, _ := m.Verify(refreshed.Token)
+	if next.Session() != tk.Session() {
+		t.Fatal("session changed")
+	}
+	if _, err = m.Refresh(pair.RefreshToken); err != ErrRevoked {
+		t.Fatal("refresh token reused", err)
+	}
+
+	//按令牌吊销
+	if err = m.Revoke(pair.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Verify(pair.Token); err != ErrRevoked {
+		t.Fatal("revoked token accepted", err)
+	}
+	if _, err = m.Verify(refreshed.Token); err != nil {
+		t.Fatal(err)
+	}
+	//按会话吊销，包括刷新得到的令牌
+	if err = m.RevokeSession(tk.Session()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Verify(refreshed.Token); err != ErrRevoked {
+		t.Fatal("session token accepted", err)
+	}
+	if _, err = m.Refresh(refreshed.RefreshToken); err != ErrRevoked {
+		t.Fatal("session refresh accepted", err)
+	}
+	//按用户吊销，之后签发的不受影响
+	other := m.Issue(7)
+	if err = m.RevokeUser(7); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Verify(other.Token); err != ErrRevoked {
+		t.Fatal("user token accepted", err)
+	}
+	if _, err = m.Verify(m.Issue(7).Token); err != nil {
+		t.Fatal(err)
+	}
+
+	//吊销之后客户端自己编码的新令牌，时间戳和session都是新的，签名不对
+	forged := Token{Id: 7}
+	bs, _ := cipher.Base64DecryptBytes(forged.Encode())
+	bs[len(bs)-1] ^= 0xff
+	if _, err = m.Verify(cipher.Base64EncryptBytes(bs)); err != ErrInvalid {
+		t.Fatal("forged token accepted", err)
+	}
+
+	//没有配置时不检查有效期
+	old := Token{Id: 1}
+	if _, err = (*Manager)(nil).Verify(old.Encode()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManager_concurrentRefresh(t *testing.T) {
+	id.Server{Node: 1}.Run()
+	Server{Secret: testSecret}.Run()
+	m := &Manager{TTL: time.Hour, Store: NewLocalRevokeStore()}
+	pair := m.Issue(8)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded, revoked := 0, 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Refresh(pair.RefreshToken)
+			mu.Lock()
+			defer mu.Unlock()
+			switch err {
+			case nil:
+				succeeded++
+			case ErrRevoked:
+				revoked++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded != 1 || revoked != 19 {
+		t.Fatal(succeeded, revoked)
+	}
+}
+
+func TestManager_noStore(t *testing.T) {
+	id.Server{Node: 1}.Run()
+	Server{Secret: testSecret}.Run()
+	//没有启动 redis、badger，也没有配置 Store
+	m := &Manager{TTL: time.Hour}
+	pair := m.Issue(9)
+	if _, err := m.Verify(pair.Token); err != ErrNoStore {
+		t.Fatal("verify without store", err)
+	}
+	if err := m.Revoke(pair.Token); err != ErrNoStore {
+		t.Fatal("revoke without store", err)
+	}
+	if _, err := m.Refresh(pair.RefreshToken); err != ErrNoStore {
+		t.Fatal("refresh without store", err)
+	}
+}
@@ -2,20 +2,109 @@ package token
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/binary"
+	"fmt"
 	"github.com/qiaojun2016/basic/cipher"
 	"github.com/qiaojun2016/basic/id"
+	"log"
+	"sync"
 	"time"
 )
 
-type Token struct {
-	Id        int64
-	session   int64
-	timestamp int64
+//令牌格式
+/*
+base64(时间戳(8) + id(8) + session(8) [+ 刷新令牌标记(1)] + hmac(16))
+hmac 为 HMAC-SHA256(密钥, 前面的字节) 的前16字节，密钥只在服务端，见 Server。
+令牌内容是明文，客户端可以用 Parse 读取，但不能伪造或修改
+升级之前签发的旧令牌没有 hmac，只在 Server.LegacyUntil 之前接受
+*/
+
+type (
+	Token struct {
+		Id        int64
+		session   int64
+		timestamp int64
+		refresh   bool //刷新令牌，只能用于 Manager.Refresh
+	}
+
+	// Server 令牌的配置，签发和校验令牌之前调用 Run，http.Server、ws.Server 启动时检查
+	Server struct {
+		Secret []byte //签名的密钥，至少16字节，多个实例必须相同，重启后不变，否则之前签发的令牌全部失效
+		//在该时间之前接受没有签名的旧令牌，用于从旧版本升级，为零值时不接受。
+		//过渡期内旧格式的令牌可以被伪造，应设置为旧令牌的最长有效期，过后删除
+		LegacyUntil time.Time
+	}
+)
+
+const (
+	refreshFlag = 1  //刷新令牌在 时间戳+id+session 之后多一个字节
+	payloadSize = 24 //时间戳+id+session，也是旧令牌的长度
+	macSize     = 16
+)
+
+var (
+	secretMu    sync.RWMutex
+	secret      []byte
+	legacyUntil time.Time
+)
+
+// Run 设置密钥，Secret 不足16字节时结束程序
+func (s Server) Run() {
+	SetSecret(s.Secret)
+	secretMu.Lock()
+	legacyUntil = s.LegacyUntil
+	secretMu.Unlock()
+	if !s.LegacyUntil.IsZero() {
+		log.Println("[token] accept unsigned legacy tokens until", s.LegacyUntil.Format(time.RFC3339))
+	}
 }
 
-// Encode 编码
+// SetSecret 设置令牌签名的密钥，多个实例必须使用相同的密钥，一般使用 Server.Run
+func SetSecret(key []byte) {
+	if len(key) < 16 {
+		log.Panicln("[token] secret must be at least 16 bytes")
+	}
+	secretMu.Lock()
+	secret = append([]byte{}, key...)
+	secretMu.Unlock()
+}
+
+// HasSecret 是否已设置密钥，没有设置时不能签发和校验令牌
+func HasSecret() bool {
+	secretMu.RLock()
+	defer secretMu.RUnlock()
+	return secret != nil
+}
+
+// legacy 是否在接受旧令牌的过渡期内
+func legacy() bool {
+	secretMu.RLock()
+	defer secretMu.RUnlock()
+	return time.Now().Before(legacyUntil)
+}
+
+// mac 签名，没有设置密钥时返回nil
+func mac(payload []byte) []byte {
+	secretMu.RLock()
+	key := secret
+	secretMu.RUnlock()
+	if key == nil {
+		return nil
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(payload)
+	return h.Sum(nil)[:macSize]
+}
+
+// Encode 编码，每次编码生成新的session
 func (t *Token) Encode() string {
+	return t.encode(id.SId.Int(), false)
+}
+
+// encode 编码，session 为令牌所属的会话，刷新时保持不变
+func (t *Token) encode(session int64, refresh bool) string {
 	//加入时间戳
 	t.timestamp = time.Now().UnixNano()
 	tsBytes := make([]byte, 8)
@@ -26,30 +115,81 @@ func (t *Token) Encode() string {
 	binary.LittleEndian.PutUint64(idBytes, uint64(t.Id))
 
 	//加入session
-	t.session = id.SId.Int()
+	t.session = session
+	t.refresh = refresh
 	sessionBytes := make([]byte, 8)
 	binary.LittleEndian.PutUint64(sessionBytes, uint64(t.session))
 
 	//合并
 	tsBytes = append(tsBytes, idBytes...)
 	tsBytes = append(tsBytes, sessionBytes...)
+	if refresh {
+		tsBytes = append(tsBytes, refreshFlag)
+	}
+
+	//签名，没有密钥时签发的令牌无法校验，直接结束
+	sum := mac(tsBytes)
+	if sum == nil {
+		log.Panicln("[token]", ErrNoSecret)
+	}
+	tsBytes = append(tsBytes, sum...)
 
 	//返回string
 	return cipher.Base64EncryptBytes(tsBytes)
 }
 
+// AccessKeyID 签名用的key，由令牌的明文计算，得到令牌的人都可以计算
 func (t *Token) AccessKeyID() string {
 	return cipher.Base64EncryptInt64((t.timestamp + t.Id) * 2)
 }
 
-// Decode 解码
-func (t *Token) Decode(token string) (err error) {
+// Decode 解码并校验签名，令牌被伪造或修改时返回 ErrInvalid，没有设置密钥时返回 ErrNoSecret。
+// 服务端必须使用 Decode 或 Manager.Verify
+func (t *Token) Decode(token string) error {
+	payload, sum, err := t.parse(token)
+	if err != nil {
+		return err
+	}
+	expected := mac(payload)
+	if expected == nil {
+		return ErrNoSecret
+	}
+	if sum == nil {
+		//旧令牌
+		if legacy() {
+			return nil
+		}
+		return ErrInvalid
+	}
+	if !hmac.Equal(sum, expected) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// Parse 只解码不校验签名，用于客户端计算 AccessKeyID，结果不可信
+func (t *Token) Parse(token string) error {
+	_, _, err := t.parse(token)
+	return err
+}
+
+// parse 解码，返回签名的数据和签名，旧令牌的签名为nil
+func (t *Token) parse(token string) (payload, sum []byte, err error) {
 	bs, err := cipher.Base64DecryptBytes(token)
 	if err != nil {
 		return
 	}
+	switch len(bs) {
+	case payloadSize:
+		payload = bs
+	case payloadSize + macSize, payloadSize + 1 + macSize:
+		payload, sum = bs[:len(bs)-macSize], bs[len(bs)-macSize:]
+	default:
+		err = fmt.Errorf("%w: length %d", ErrInvalid, len(bs))
+		return
+	}
 
-	buff := bytes.NewBuffer(bs)
+	buff := bytes.NewBuffer(payload)
 	b := make([]byte, 8)
 
 	//提取时间戳
@@ -72,6 +212,13 @@ func (t *Token) Decode(token string) (err error) {
 		return
 	}
 	t.session = int64(binary.LittleEndian.Uint64(b))
+
+	//刷新令牌的标记，访问令牌没有
+	flag, e := buff.ReadByte()
+	t.refresh = e == nil && flag == refreshFlag
+	if e == nil && flag != refreshFlag {
+		err = ErrInvalid
+	}
 	return
 }
 
@@ -82,3 +229,18 @@ func (t *Token) Session() int64 {
 func (t *Token) Timestamp() int64 {
 	return t.timestamp
 }
+
+// IssuedAt 签发时间
+func (t *Token) IssuedAt() time.Time {
+	return time.Unix(0, t.timestamp)
+}
+
+// Expired 签发超过 ttl 时返回true，ttl 为0时不过期
+func (t *Token) Expired(ttl time.Duration) bool {
+	return ttl > 0 && time.Since(t.IssuedAt()) > ttl
+}
+
+// IsRefresh 是否是刷新令牌
+func (t *Token) IsRefresh() bool {
+	return t.refresh
+}
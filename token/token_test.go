@@ -0,0 +1,105 @@
+package token
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/qiaojun2016/basic/cipher"
+	"github.com/qiaojun2016/basic/id"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("server-secret-0123456789")
+
+func TestToken_forged(t *testing.T) {
+	id.Server{Node: 1}.Run()
+	Server{Secret: testSecret}.Run()
+	tk := Token{Id: 42}
+	s := tk.Encode()
+	got := Token{}
+	if err := got.Decode(s); err != nil || got.Id != 42 || got.Session() != tk.Session() {
+		t.Fatal(got, err)
+	}
+
+	//客户端可以解码，但修改id之后签名不对
+	bs, _ := cipher.Base64DecryptBytes(s)
+	binary.LittleEndian.PutUint64(bs[8:16], 1)
+	if err := got.Decode(cipher.Base64EncryptBytes(bs)); !errors.Is(err, ErrInvalid) {
+		t.Fatal("tampered token accepted", err)
+	}
+	//不知道密钥时重新编码的令牌
+	payload := make([]byte, payloadSize+macSize)
+	binary.LittleEndian.PutUint64(payload[0:8], uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint64(payload[8:16], 1)
+	binary.LittleEndian.PutUint64(payload[16:24], 7)
+	if err := got.Decode(cipher.Base64EncryptBytes(payload)); !errors.Is(err, ErrInvalid) {
+		t.Fatal("forged token accepted", err)
+	}
+	if err := got.Parse(cipher.Base64EncryptBytes(payload)); err != nil || got.Id != 1 {
+		t.Fatal("parse", err)
+	}
+	//其他密钥签发的令牌
+	SetSecret([]byte("another-secret-0123456789"))
+	defer SetSecret(testSecret)
+	if err := got.Decode(s); !errors.Is(err, ErrInvalid) {
+		t.Fatal("token of another secret accepted", err)
+	}
+	//旧格式的令牌没有签名
+	if err := got.Decode(cipher.Base64EncryptBytes(bs[:payloadSize])); !errors.Is(err, ErrInvalid) {
+		t.Fatal("unsigned token accepted", err)
+	}
+}
+
+func TestToken_legacy(t *testing.T) {
+	id.Server{Node: 1}.Run()
+	Server{Secret: testSecret}.Run()
+	tk := Token{Id: 42}
+	bs, _ := cipher.Base64DecryptBytes(tk.Encode())
+	old := cipher.Base64EncryptBytes(bs[:payloadSize])
+
+	//过渡期内接受没有签名的旧令牌，AccessKeyID 不变
+	Server{Secret: testSecret, LegacyUntil: time.Now().Add(time.Hour)}.Run()
+	got := Token{}
+	if err := got.Decode(old); err != nil || got.Id != 42 || got.AccessKeyID() != tk.AccessKeyID() {
+		t.Fatal(got, err)
+	}
+	//过渡期内签名错误的新令牌仍然拒绝
+	bs[len(bs)-1] ^= 0xff
+	if err := got.Decode(cipher.Base64EncryptBytes(bs)); !errors.Is(err, ErrInvalid) {
+		t.Fatal("tampered token accepted", err)
+	}
+	//过渡期结束
+	Server{Secret: testSecret, LegacyUntil: time.Now().Add(-time.Second)}.Run()
+	if err := got.Decode(old); !errors.Is(err, ErrInvalid) {
+		t.Fatal("legacy token accepted", err)
+	}
+	Server{Secret: testSecret}.Run()
+}
+
+func TestToken_noSecret(t *testing.T) {
+	id.Server{Node: 1}.Run()
+	Server{Secret: testSecret}.Run()
+	tk := Token{Id: 42}
+	s := tk.Encode()
+	secretMu.Lock()
+	secret = nil
+	secretMu.Unlock()
+	defer SetSecret(testSecret)
+
+	if HasSecret() {
+		t.Fatal("secret set")
+	}
+	//没有密钥时不能校验，也不能签发
+	if err := tk.Decode(s); err != ErrNoSecret {
+		t.Fatal(err)
+	}
+	if _, err := (&Manager{Store: NewLocalRevokeStore()}).Verify(s); err != ErrNoSecret {
+		t.Fatal(err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("encode without secret")
+		}
+	}()
+	tk.Encode()
+}
@@ -0,0 +1,51 @@
+// Package trace 请求id，通过 context.Context 在 http、request、redis、mysql 之间传递
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header 请求id的http头，请求中带有时沿用，否则生成新的，并在返回中回显
+const Header = "X-Request-Id"
+
+// maxLength 沿用请求中的id时允许的最大长度
+const maxLength = 64
+
+type key struct{}
+
+// WithId 把请求id放入ctx
+func WithId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// Id 取出ctx中的请求id，没有时为空
+func Id(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
+
+// NewId 生成32位十六进制的请求id
+func NewId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// FromHeader 沿用请求头中的id，为空或包含非法字符时生成新的
+func FromHeader(id string) string {
+	if id == "" || len(id) > maxLength {
+		return NewId()
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_' || c == '.') {
+			return NewId()
+		}
+	}
+	return id
+}
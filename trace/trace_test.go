@@ -0,0 +1,20 @@
+package trace
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFromHeader(t *testing.T) {
+	if id := FromHeader("abc-123_x.y"); id != "abc-123_x.y" {
+		t.Fatal(id)
+	}
+	for _, bad := range []string{"", "a b", "a\nb", string(make([]byte, 65))} {
+		if id := FromHeader(bad); id == bad || len(id) != 32 {
+			t.Fatalf("%q -> %q", bad, id)
+		}
+	}
+	if Id(context.Background()) != "" || Id(WithId(context.Background(), "x")) != "x" {
+		t.Fatal("context value")
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"github.com/qiaojun2016/basic/cipher"
 	"github.com/qiaojun2016/basic/color"
+	"github.com/qiaojun2016/basic/health"
 	"github.com/qiaojun2016/basic/id"
 	"github.com/qiaojun2016/basic/ip"
+	"github.com/qiaojun2016/basic/metrics"
+	"github.com/qiaojun2016/basic/tlsServer"
 	"github.com/qiaojun2016/basic/token"
 	"github.com/gorilla/websocket"
 	"log"
@@ -24,16 +27,22 @@ type (
 	OnConn    func(uid, data string) error
 
 	Server struct {
-		Addr            string    //监听地址
-		ReadBufferSize  int       //最大消息长度
-		WriteBufferSize int       //最大head息长度
-		Origin          bool      //是否是用于web，跨域
-		UserAgent       string    //允许的UserAgent
-		OnStart         OnStart   //启动时
-		OnClose         OnClose   //当关闭一个链接时
-		OnMessage       OnMessage //当收到消息
-		OnConn          OnConn    //当链接时，data的内容是链接参数的d参数
-		Block           bool      //当主协程能自己维持，block不用开启
+		Addr            string         //监听地址
+		ReadBufferSize  int            //最大消息长度
+		WriteBufferSize int            //最大head息长度
+		Origin          bool           //是否是用于web，跨域
+		UserAgent       string         //允许的UserAgent
+		OnStart         OnStart        //启动时
+		OnClose         OnClose        //当关闭一个链接时
+		OnMessage       OnMessage      //当收到消息
+		OnConn          OnConn         //当链接时，data的内容是链接参数的d参数
+		Block           bool           //当主协程能自己维持，block不用开启
+		TLS             *tlsServer.TLS //证书配置，为空时监听ws
+		IpResolver      *ip.Resolver   //客户端ip，为空时使用 ip.DefaultResolver
+		AllowIps        []string       //允许的ip或网段，为空时不限制
+		DenyIps         []string       //拒绝的ip或网段，优先于 AllowIps
+		BanList         *ip.BanList    //自动封禁，签名错误记为违规，可以与 http.Server 共用
+		Tokens          *token.Manager //令牌的有效期和吊销，过期或吊销时返回401，可以与 http.Server 共用，启动前必须运行 token.Server
 	}
 
 	server struct {
@@ -49,6 +58,14 @@ type (
 
 var clients map[string]*client
 
+// 指标
+var (
+	connections      = metrics.NewGauge("basic_ws_connections", "当前连接数")
+	connectsTotal    = metrics.NewCounter("basic_ws_connects_total", "建立的连接数")
+	messagesReceived = metrics.NewCounter("basic_ws_messages_received_total", "收到的消息数")
+	messagesSent     = metrics.NewCounter("basic_ws_messages_sent_total", "发送的消息数")
+)
+
 //var onStart OnStart
 var onClose OnClose
 var onMessage OnMessage
@@ -65,6 +82,10 @@ const (
 )
 
 func (s Server) Run() {
+	//令牌的密钥，见 token.Server
+	if !token.HasSecret() {
+		log.Panicln("[ws]", token.ErrNoSecret)
+	}
 	err := s.OnStart()
 	if err != nil {
 		log.Println(err)
@@ -97,6 +118,14 @@ func (s Server) Run() {
 	onMessage = s.OnMessage
 
 	clients = make(map[string]*client)
+	resolver := s.IpResolver
+	if resolver == nil {
+		resolver = ip.DefaultResolver
+	}
+	access, err := ip.NewAccess(s.AllowIps, s.DenyIps)
+	if err != nil {
+		log.Panicf("[ws] %s", err)
+	}
 
 	//s:签名
 	//t:token
@@ -105,6 +134,12 @@ func (s Server) Run() {
 	//签名的数据为 sec+t+d
 	//s=signature&t=token&sec=xxxx&d=p,c,d
 	sh := func(w http.ResponseWriter, r *http.Request) {
+		clientIp := resolver.ClientIp(r)
+		if s.BanList.Banned(clientIp) || !access.Allowed(clientIp) {
+			log.Println(clientIp, "ip denied")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 		//解析参数
 		var m = make(map[string]string)
 		for key, value := range r.URL.Query() {
@@ -127,7 +162,7 @@ func (s Server) Run() {
 			}
 
 			if time.Now().Unix()-i64 > 15 {
-				log.Println("connection time out")
+				log.Println(clientIp, "connection time out")
 				return
 			}
 		} else {
@@ -160,17 +195,29 @@ func (s Server) Run() {
 			return
 		}
 
-		//提取token信息
-		tk := token.Token{}
-		if err = tk.Decode(token_); err != nil {
-			log.Println("decode token err:", err)
+		//提取token信息，检查有效期和吊销
+		tk, err := s.Tokens.Verify(token_)
+		switch err {
+		case nil:
+		case token.ErrExpired, token.ErrRevoked:
+			log.Println(clientIp, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case token.ErrInvalid:
+			//不记为违规，密钥配置错误时正常的客户端也会失败
+			log.Println(clientIp, "decode token err:", err)
+			return
+		default:
+			log.Println(clientIp, err)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		userId := id.SId.ToString(tk.Id)
 
 		//检查签名
 		if !cipher.CheckSign(signature, []byte(sec+token_+data), []byte(tk.AccessKeyID())) {
-			log.Println("signature err")
+			log.Println(clientIp, "signature err")
+			s.BanList.Strike(clientIp, "signature mismatch")
 			return
 		}
 
@@ -215,15 +262,20 @@ func (s Server) Run() {
 
 		//加入clients列表
 		clients[userId] = c
+		connections.Inc()
+		connectsTotal.Inc()
 		//启动每一个conn
 		go c.pump()
 	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", sh)
 
+	listener := &health.Listener{}
+	health.Register("ws", listener)
 	go func() {
-		err = http.ListenAndServe(s.Addr, mux)
+		err = tlsServer.ListenAndServe(&http.Server{Addr: s.Addr, Handler: mux}, s.TLS)
 		if err != nil {
+			listener.Fail(err)
 			log.Println("[ws] Listen error!", err)
 			return
 		}
@@ -251,9 +303,14 @@ func (s Server) Run() {
 		return
 	}
 
+	scheme := "ws"
+	if s.TLS != nil {
+		scheme = "wss"
+	}
 	color.Success(fmt.Sprintf(
-		"[ws] %s listening ws://%s%s ",
+		"[ws] %s listening %s://%s%s ",
 		s.UserAgent,
+		scheme,
 		ips[0],
 		s.Addr,
 	))
@@ -285,6 +342,7 @@ func (c *client) pump() {
 				break
 			}
 			//收到的消息
+			messagesReceived.Inc()
 			onMessage(message)
 		}
 	}()
@@ -327,6 +385,7 @@ func (c *client) close() {
 	//删除
 	if _, ok := clients[c.userId]; ok {
 		delete(clients, c.userId)
+		connections.Dec()
 	}
 	//log.Println("close conn")
 }
@@ -356,5 +415,6 @@ func (c *client) send(data []byte) (err error) {
 		c.close()
 		return
 	}
+	messagesSent.Inc()
 	return
 }